@@ -9,10 +9,13 @@
 package cmd
 
 import (
+	"fmt"
 	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/xigxog/fox/internal/log"
 	"github.com/xigxog/fox/internal/repo"
+	"github.com/xigxog/fox/pkg/fox"
 )
 
 var buildCmd = &cobra.Command{
@@ -26,10 +29,25 @@ The build command will use Docker to build the specified component. By default
 components are built using a KubeFox defined Dockerfile. A custom Dockerfile can
 be provided my placing it in the root directory of the component. Please note
 that the build working directory is the root of the repository, not the
-component directory.`),
+component directory.
+
+The 'builder' flag selects the backend used to build images. The 'docker'
+builder requires access to a Docker daemon. The 'buildah' builder uses the
+buildah CLI and does not require a daemon, making it suitable for CI runners
+without a Docker socket. The 'native' builder compiles Go components on the host
+and assembles the image directly without Docker or a Dockerfile. Images built
+by the native builder are stored in an OCI image layout, by default in the
+user's cache directory. Components with a custom Dockerfile cannot be built
+with the native builder.`),
 	Example: strings.TrimSpace(`
 # Build and push OCI image for my-component.
-fox build my-component --publish`),
+fox build my-component --publish
+
+# Build OCI image for my-component without a Docker daemon.
+fox build my-component --builder buildah
+
+# Build and push OCI image for my-component using the Go toolchain on the host.
+fox build my-component --builder native --push`),
 }
 
 func init() {
@@ -40,12 +58,29 @@ func init() {
 }
 
 func addCommonBuildFlags(cmd *cobra.Command) {
+	addBuilderFlag(cmd)
 	cmd.Flags().StringVarP(&cfg.Flags.Kind, "kind", "k", "", "if provided the built image will be loaded into the kind cluster")
 	cmd.Flags().BoolVarP(&cfg.Flags.NoCache, "no-cache", "", false, "do not use cache when building image")
 	cmd.Flags().BoolVarP(&cfg.Flags.ForceBuild, "force", "", false, "force build even if component image exists")
 }
 
+func addBuilderFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&cfg.Flags.Builder, "builder", "", repo.BuilderDocker,
+		fmt.Sprintf(`backend used to build and inspect images, one of ["%s"]`, strings.Join(repo.Builders, `", "`)))
+	cmd.Flags().StringVarP(&cfg.Flags.OCILayout, "oci-layout", "", "", "directory of OCI image layout used to store images built by the native builder")
+}
+
 func runBuild(cmd *cobra.Command, args []string) {
-	r := repo.New(cfg)
-	r.Build(args[0])
+	c, ctx, cancel := newClient()
+	defer cancel()
+
+	_, err := c.Build(ctx, fox.BuildOptions{
+		Component: args[0],
+		Push:      cfg.Flags.PushImage,
+		NoCache:   cfg.Flags.NoCache,
+		Force:     cfg.Flags.ForceBuild,
+	})
+	if err != nil {
+		log.Fatal("%v", err)
+	}
 }
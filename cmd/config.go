@@ -38,7 +38,9 @@ var cfgSetupCmd = &cobra.Command{
 	PreRun: setup,
 	Run: func(cmd *cobra.Command, args []string) {
 		if !cfg.Fresh {
-			cfg.Setup()
+			if err := cfg.Setup(); err != nil {
+				log.Fatal("%v", err)
+			}
 		}
 	},
 	Short: "Run setup to configure 🦊 Fox",
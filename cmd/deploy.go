@@ -10,8 +10,9 @@ package cmd
 
 import (
 	"github.com/spf13/cobra"
+	"github.com/xigxog/fox/internal/errs"
 	"github.com/xigxog/fox/internal/log"
-	"github.com/xigxog/fox/internal/repo"
+	"github.com/xigxog/fox/pkg/fox"
 	"github.com/xigxog/kubefox/utils"
 )
 
@@ -29,6 +30,9 @@ func init() {
 	deployCmd.Flags().StringVarP(&cfg.Flags.Version, "version", "s", "", "version to assign to the AppDeployment, making it immutable")
 	deployCmd.Flags().BoolVarP(&cfg.Flags.CreateTag, "create-tag", "t", false, `create Git tag using the AppDeployment version`)
 	deployCmd.Flags().BoolVarP(&cfg.Flags.Generate, "generate", "g", false, `only generate AppDeployment and exit`)
+	deployCmd.Flags().BoolVarP(&cfg.Flags.Diff, "diff", "", false, `show changes to the existing AppDeployment and confirm them before applying, changes are not applied without a prompt unless the 'yes' flag is set`)
+	addDirtyFlag(deployCmd)
+	addBuilderFlag(deployCmd)
 	addCommonDeployFlags(deployCmd)
 	rootCmd.AddCommand(deployCmd)
 }
@@ -36,26 +40,52 @@ func init() {
 func addCommonDeployFlags(cmd *cobra.Command) {
 	cmd.Flags().StringVarP(&cfg.Flags.Namespace, "namespace", "n", "", "namespace of KubeFox Platform")
 	cmd.Flags().StringVarP(&cfg.Flags.Platform, "platform", "p", "", "name of KubeFox Platform to utilize")
-	cmd.Flags().DurationVarP(&cfg.Flags.WaitTime, "wait", "", 0, "wait up to the specified time for components to be ready")
+	cmd.Flags().DurationVarP(&cfg.Flags.WaitTime, "wait", "", 0, "wait up to the specified time for components to be ready and resources to be available")
 	cmd.Flags().BoolVarP(&cfg.Flags.DryRun, "dry-run", "", false, "submit server-side request without persisting the resource")
 }
 
+func addDirtyFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&cfg.Flags.Dirty, "dirty", "", false, `allow uncommitted changes, the AppDeployment is given a synthetic commit and cannot be released`)
+}
+
 func checkCommonDeployFlags() {
 	if cfg.Flags.Platform != "" && cfg.Flags.Namespace == "" {
-		log.Fatal("'namespace' flag required if 'platform' flag is provided.")
+		log.Fatal("%v", errs.New(errs.TypeUsage, "'namespace' flag required if 'platform' flag is provided."))
+	}
+	if cfg.Flags.Dirty && cfg.Flags.Version != "" {
+		log.Fatal("%v", errs.New(errs.TypeUsage, "'version' flag cannot be used with 'dirty' flag, only committed changes can be versioned."))
 	}
 	if cfg.Flags.CreateTag && cfg.Flags.Version == "" {
-		log.Fatal("'version' flag required if 'create-tag' flag is set.")
+		log.Fatal("%v", errs.New(errs.TypeUsage, "'version' flag required if 'create-tag' flag is set."))
 	}
 	if cfg.Flags.AppDeployment != "" && !utils.IsValidName(cfg.Flags.AppDeployment) {
-		log.Fatal("Invalid resource name, valid names contain only lowercase alpha-numeric characters and dashes.")
+		log.Fatal("%v", errs.New(errs.TypeUsage, "Invalid resource name, valid names contain only lowercase alpha-numeric characters and dashes."))
+	}
+}
+
+func deployOptions() fox.DeployOptions {
+	return fox.DeployOptions{
+		Name:      cfg.Flags.AppDeployment,
+		Version:   cfg.Flags.Version,
+		CreateTag: cfg.Flags.CreateTag,
+		Dirty:     cfg.Flags.Dirty,
+		Wait:      cfg.Flags.WaitTime,
+		DryRun:    cfg.Flags.DryRun,
+		Diff:      cfg.Flags.Diff,
+		Generate:  cfg.Flags.Generate,
 	}
 }
 
 func runDeploy(cmd *cobra.Command, args []string) error {
 	checkCommonDeployFlags()
 
-	d := repo.New(cfg).Deploy(false)
+	c, ctx, cancel := newClient()
+	defer cancel()
+
+	d, err := c.Deploy(ctx, deployOptions())
+	if err != nil {
+		log.Fatal("%v", err)
+	}
 
 	// Makes output less cluttered.
 	d.Annotations = nil
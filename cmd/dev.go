@@ -0,0 +1,79 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package cmd
+
+import (
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/xigxog/fox/internal/errs"
+	"github.com/xigxog/fox/internal/log"
+	"github.com/xigxog/fox/internal/repo"
+)
+
+var devCmd = &cobra.Command{
+	Use:    "dev",
+	Args:   cobra.NoArgs,
+	PreRun: setup,
+	Run:    runDev,
+	Short:  "Continuously build and deploy KubeFox App from the working directory",
+	Long: strings.TrimSpace(`
+The dev command builds all components from the working directory, including
+uncommitted changes, and deploys them to a personal AppDeployment. It then
+watches the repo for changes. When a change is detected only the components
+whose hash changed are rebuilt and loaded into the kind cluster, and the
+AppDeployment is updated. Changes to other files, such as 'app.yaml', also update
+the AppDeployment. If the update fails it is retried on the next change.
+
+A proxy is started after the first deployment which injects the personal
+AppDeployment, and the 'virtual-env' if provided, into requests. See the proxy
+command for details. Files matching patterns in the repo's '.dockerignore' are
+not watched.
+`),
+	Example: strings.TrimSpace(`
+# Watch for changes and proxy local port 8080 using 'my-env' as context.
+fox dev --virtual-env my-env
+
+# Watch for changes without starting a proxy.
+fox dev --port 0
+`),
+}
+
+var (
+	devPort int
+)
+
+func init() {
+	devCmd.Flags().StringVarP(&cfg.Flags.AppDeployment, "name", "d", "", `name to use for AppDeployment, defaults to <APP NAME>-dev-<USER>`)
+	devCmd.Flags().StringVarP(&cfg.Flags.VirtEnv, "virtual-env", "e", "", "environment to add to proxied requests")
+	devCmd.Flags().IntVarP(&devPort, "port", "", 8080, "local port of proxy, set to 0 to disable proxy")
+	devCmd.Flags().IntVarP(&cfg.Flags.Parallel, "parallel", "", 1, `number of components to build concurrently`)
+	addCommonBuildFlags(devCmd)
+	addCommonDeployFlags(devCmd)
+
+	rootCmd.AddCommand(devCmd)
+}
+
+func runDev(cmd *cobra.Command, args []string) {
+	checkCommonDeployFlags()
+	if cfg.Flags.Parallel < 1 {
+		log.Fatal("%v", errs.New(errs.TypeUsage, "'parallel' flag must be at least 1."))
+	}
+	if devPort < 0 {
+		log.Fatal("%v", errs.New(errs.TypeUsage, "'port' flag must not be negative."))
+	}
+
+	r, err := repo.New(cfg)
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+	if err := r.Dev(devPort); err != nil {
+		log.Fatal("%v", err)
+	}
+}
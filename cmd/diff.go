@@ -0,0 +1,74 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package cmd
+
+import (
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/xigxog/fox/internal/log"
+)
+
+var diffCmd = &cobra.Command{
+	Use:    "diff",
+	Args:   cobra.NoArgs,
+	PreRun: setup,
+	Run:    runDiff,
+	Short:  "Show changes deploying the currently checked out Git commit would make",
+	Long: strings.TrimSpace(`
+The diff command generates the AppDeployment for the currently checked out Git
+commit and compares it with the AppDeployment of the same name on the cluster.
+Changes are shown for each field, including the hash, image, routes, and
+dependencies of each component. Nothing is built or deployed; if a component's
+image does not exist only its hash and image are compared.
+
+Output is a unified diff unless the 'output' flag is set to 'json' or 'yaml',
+in which case the list of changes is output. To review changes and then apply
+them use 'fox deploy --diff'.
+`),
+	Example: strings.TrimSpace(`
+# Show changes to the AppDeployment of the current branch.
+fox diff
+
+# Output the changes as JSON.
+fox diff -o json
+`),
+}
+
+func init() {
+	diffCmd.Flags().StringVarP(&cfg.Flags.AppDeployment, "name", "d", "", `name of AppDeployment, defaults to <APP NAME>-<VERSION | GIT REF | GIT COMMIT>`)
+	diffCmd.Flags().StringVarP(&cfg.Flags.Version, "version", "s", "", "version of the AppDeployment")
+	diffCmd.Flags().StringVarP(&cfg.Flags.Namespace, "namespace", "n", "", "namespace of KubeFox Platform")
+	diffCmd.Flags().StringVarP(&cfg.Flags.Platform, "platform", "p", "", "name of KubeFox Platform to utilize")
+	addDirtyFlag(diffCmd)
+	addBuilderFlag(diffCmd)
+
+	rootCmd.AddCommand(diffCmd)
+}
+
+func runDiff(cmd *cobra.Command, args []string) {
+	checkCommonDeployFlags()
+
+	c, ctx, cancel := newClient()
+	defer cancel()
+
+	d, err := c.Diff(ctx, deployOptions())
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+
+	switch {
+	case cmd.Flags().Changed("output"):
+		log.Marshal(d)
+	case !d.HasChanges():
+		log.Info("AppDeployment '%s' is unchanged.", d.Name)
+	default:
+		log.Printf("%s", d.Unified())
+	}
+}
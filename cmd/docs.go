@@ -41,7 +41,9 @@ func generateDocs(cmd *cobra.Command, args []string) {
 	log.Verbose("Generating docs")
 
 	// ensure dir exists
-	utils.EnsureDir(docsDir)
+	if err := utils.EnsureDir(docsDir); err != nil {
+		log.Fatal("%v", err)
+	}
 
 	// remove any existing markdown files
 	mdFiles, err := filepath.Glob(docsDir + "/*.md")
@@ -0,0 +1,212 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/xigxog/fox/internal/env"
+	"github.com/xigxog/fox/internal/log"
+	"github.com/xigxog/kubefox/api"
+	"github.com/xigxog/kubefox/api/kubernetes/v1alpha1"
+	"github.com/xigxog/kubefox/k8s"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var envCmd = &cobra.Command{
+	Use:     "env",
+	Aliases: []string{"environment"},
+	Short:   "Apply and manage Environments",
+	Long: strings.TrimSpace(`
+The env command and its subcommands apply and manage the Environments of the
+KubeFox Platform. Environments are cluster scoped and provide the default vars
+and Release policy of the VirtualEnvironments that are part of them.
+`),
+}
+
+var virtEnvCmd = &cobra.Command{
+	Use:     "virtual-env",
+	Aliases: []string{"virtualenv", "ve"},
+	Short:   "Apply and manage VirtualEnvironments",
+	Long: strings.TrimSpace(`
+The virtual-env command and its subcommands apply and manage the
+VirtualEnvironments of the KubeFox Platform. VirtualEnvironments are created in
+the namespace of the KubeFox Platform and must be part of an Environment.
+`),
+}
+
+func init() {
+	addEnvCmds(envCmd, env.KindEnvironment, "env")
+	addEnvCmds(virtEnvCmd, env.KindVirtualEnv, "virtual-env")
+
+	rootCmd.AddCommand(envCmd)
+	rootCmd.AddCommand(virtEnvCmd)
+}
+
+// addEnvCmds adds the apply, list, get, and delete subcommands for kind to
+// parent.
+func addEnvCmds(parent *cobra.Command, kind, use string) {
+	applyCmd := &cobra.Command{
+		Use:    "apply [PATH...]",
+		PreRun: setup,
+		Run: func(cmd *cobra.Command, args []string) {
+			checkCommonDeployFlags()
+			if len(args) == 0 {
+				if err := cfg.CleanPaths(false); err != nil {
+					log.Fatal("%v", err)
+				}
+				args = []string{filepath.Join(cfg.AppPath, env.DefaultDir)}
+			}
+			m, err := env.New(cfg, kind)
+			if err != nil {
+				log.Fatal("%v", err)
+			}
+			objs, err := m.Apply(args)
+			if err != nil {
+				log.Fatal("%v", err)
+			}
+			for _, obj := range objs {
+				// Makes output less cluttered.
+				obj.SetAnnotations(nil)
+				obj.SetManagedFields(nil)
+			}
+			log.Marshal(objs)
+		},
+		Short: fmt.Sprintf("Apply %ss from YAML or JSON files", kind),
+		Long: strings.TrimSpace(fmt.Sprintf(`
+The apply command reads %[1]ss from the provided files or directories,
+validates them, and server-side applies them to the cluster. Documents of other
+kinds are skipped, allowing files that contain both Environments and
+VirtualEnvironments to be used. If no paths are provided the app's '%[2]s'
+directory is used.
+`, kind, env.DefaultDir)),
+		Example: strings.TrimSpace(fmt.Sprintf(`
+# Apply the %[1]ss in the app's '%[2]s' directory.
+fox %[3]s apply
+
+# Validate the %[1]ss in 'qa.yaml' without persisting them.
+fox %[3]s apply %[2]s/qa.yaml --dry-run
+`, kind, env.DefaultDir, use)),
+	}
+	applyCmd.Flags().BoolVarP(&cfg.Flags.DryRun, "dry-run", "", false, "submit server-side request without persisting the resource")
+
+	listCmd := &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Args:    cobra.NoArgs,
+		PreRun:  setupTable,
+		Run: func(cmd *cobra.Command, args []string) {
+			checkCommonDeployFlags()
+			m, err := env.New(cfg, kind)
+			if err != nil {
+				log.Fatal("%v", err)
+			}
+			objs, err := m.List()
+			if err != nil {
+				log.Fatal("%v", err)
+			}
+			if log.OutputFormat != "table" {
+				for _, obj := range objs {
+					obj.SetManagedFields(nil)
+				}
+				log.Marshal(objs)
+				return
+			}
+			printEnvs(objs)
+		},
+		Short: fmt.Sprintf("List %ss", kind),
+	}
+
+	getCmd := &cobra.Command{
+		Use:    "get <NAME>",
+		Args:   cobra.ExactArgs(1),
+		PreRun: setup,
+		Run: func(cmd *cobra.Command, args []string) {
+			checkCommonDeployFlags()
+			m, err := env.New(cfg, kind)
+			if err != nil {
+				log.Fatal("%v", err)
+			}
+			obj, err := m.Get(args[0])
+			if err != nil {
+				log.Fatal("%v", err)
+			}
+			obj.SetManagedFields(nil)
+			log.Marshal(obj)
+		},
+		Short: fmt.Sprintf("Get the %s with the specified name", kind),
+	}
+
+	deleteCmd := &cobra.Command{
+		Use:    "delete <NAME>",
+		Args:   cobra.ExactArgs(1),
+		PreRun: setup,
+		Run: func(cmd *cobra.Command, args []string) {
+			checkCommonDeployFlags()
+			m, err := env.New(cfg, kind)
+			if err != nil {
+				log.Fatal("%v", err)
+			}
+			obj, err := m.Delete(args[0])
+			if err != nil {
+				log.Fatal("%v", err)
+			}
+			obj.SetAnnotations(nil)
+			obj.SetManagedFields(nil)
+			log.Marshal(obj)
+		},
+		Short: fmt.Sprintf("Delete the %s with the specified name", kind),
+	}
+	deleteCmd.Flags().BoolVarP(&cfg.Flags.DryRun, "dry-run", "", false, "submit server-side request without persisting the resource")
+
+	for _, cmd := range []*cobra.Command{applyCmd, listCmd, getCmd, deleteCmd} {
+		if kind == env.KindVirtualEnv {
+			cmd.Flags().StringVarP(&cfg.Flags.Namespace, "namespace", "n", "", "namespace of KubeFox Platform")
+			cmd.Flags().StringVarP(&cfg.Flags.Platform, "platform", "p", "", "name of KubeFox Platform to utilize")
+		}
+		parent.AddCommand(cmd)
+	}
+}
+
+func printEnvs(objs []client.Object) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	for i, obj := range objs {
+		switch o := obj.(type) {
+		case *v1alpha1.Environment:
+			if i == 0 {
+				fmt.Fprintln(w, "NAME\tRELEASE TYPE")
+			}
+			fmt.Fprintf(w, "%s\t%s\n", o.Name, dash(string(o.Spec.ReleasePolicy.Type)))
+
+		case *v1alpha1.VirtualEnvironment:
+			if i == 0 {
+				fmt.Fprintln(w, "NAME\tENVIRONMENT\tACTIVE RELEASE\tAVAILABLE")
+			}
+			apps := []string{}
+			if rel := o.Status.ActiveRelease; rel != nil {
+				for app, a := range rel.Apps {
+					apps = append(apps, app+"="+a.AppDeployment)
+				}
+			}
+			sort.Strings(apps)
+			fmt.Fprintf(w, "%s\t%s\t%s\t%t\n", o.Name, o.Spec.Environment,
+				dash(strings.Join(apps, ", ")),
+				k8s.Condition(o.Status.Conditions, api.ConditionTypeActiveReleaseAvailable).Status == metav1.ConditionTrue)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/xigxog/fox/internal/errs"
+	"github.com/xigxog/fox/internal/log"
+	"github.com/xigxog/fox/internal/repo"
+)
+
+var gcCmd = &cobra.Command{
+	Use:    "gc",
+	Args:   cobra.NoArgs,
+	PreRun: setupTable,
+	Run:    runGC,
+	Short:  "Delete old AppDeployments of the KubeFox App",
+	Long: strings.TrimSpace(`
+The gc command deletes AppDeployments of the app that are no longer needed.
+AppDeployments are kept if any of the following are true:
+
+  - They are part of a Release of a VirtualEnvironment, including previous
+    Releases in the VirtualEnvironment's history.
+  - They are one of the newest AppDeployments of their Git branch, the number
+    kept per branch is set with the 'keep' flag.
+  - They are newer than the age set with the 'older-than' flag.
+
+All other AppDeployments are deleted. A report of the AppDeployments to keep
+and delete is shown and confirmation is requested before anything is deleted.
+Set the 'dry-run' flag to only show the report.
+`),
+	Example: strings.TrimSpace(`
+# Show which AppDeployments would be deleted.
+fox gc --dry-run
+
+# Keep the newest AppDeployment of each branch and any created within the last
+# week.
+fox gc --keep 1 --older-than 168h
+`),
+}
+
+func init() {
+	gcCmd.Flags().IntVarP(&cfg.Flags.Keep, "keep", "", 3, "number of newest AppDeployments to keep per Git branch")
+	gcCmd.Flags().DurationVarP(&cfg.Flags.OlderThan, "older-than", "", 0, "only delete AppDeployments older than the specified age")
+	gcCmd.Flags().StringVarP(&cfg.Flags.Namespace, "namespace", "n", "", "namespace of KubeFox Platform")
+	gcCmd.Flags().StringVarP(&cfg.Flags.Platform, "platform", "p", "", "name of KubeFox Platform to utilize")
+	gcCmd.Flags().BoolVarP(&cfg.Flags.DryRun, "dry-run", "", false, "only show which AppDeployments would be deleted")
+
+	rootCmd.AddCommand(gcCmd)
+}
+
+func runGC(cmd *cobra.Command, args []string) {
+	checkCommonDeployFlags()
+	if cfg.Flags.Keep < 0 {
+		log.Fatal("%v", errs.New(errs.TypeUsage, "'keep' flag must be zero or greater."))
+	}
+
+	r, err := repo.New(cfg)
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+	results, err := r.PlanGC()
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+	if log.OutputFormat == "table" {
+		printGCResults(results)
+	} else {
+		log.Marshal(results)
+	}
+
+	toDelete := 0
+	for _, res := range results {
+		if res.Action == repo.GCActionDelete {
+			toDelete++
+		}
+	}
+	switch {
+	case toDelete == 0:
+		log.Info("No AppDeployments to delete.")
+		return
+	case cfg.Flags.DryRun:
+		log.Info("Dry run, %d AppDeployments would be deleted.", toDelete)
+		return
+	}
+
+	if !cfg.Prompter().YesNo(fmt.Sprintf("Delete %d AppDeployments?", toDelete), false) {
+		log.Fatal("%v", errs.New(errs.TypeAborted, "Aborted, no AppDeployments deleted."))
+	}
+	if err := r.GC(results); err != nil {
+		log.Fatal("%v", err)
+	}
+}
+
+func printGCResults(results []repo.GCResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "APP DEPLOYMENT\tBRANCH\tCREATED\tACTION\tREASON")
+	for _, res := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", res.Name, dash(res.Branch),
+			res.Created.Format(time.RFC3339), res.Action, res.Reason)
+	}
+	w.Flush()
+}
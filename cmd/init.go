@@ -13,6 +13,7 @@ import (
 	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/xigxog/fox/internal/log"
 	"github.com/xigxog/fox/internal/repo"
 )
 
@@ -36,5 +37,7 @@ func init() {
 }
 
 func initRepo(cmd *cobra.Command, args []string) {
-	repo.Init(cfg)
+	if err := repo.Init(cfg); err != nil {
+		log.Fatal("%v", err)
+	}
 }
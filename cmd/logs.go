@@ -0,0 +1,76 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package cmd
+
+import (
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/xigxog/fox/internal/log"
+	"github.com/xigxog/fox/internal/repo"
+)
+
+var logsCmd = &cobra.Command{
+	Use:    "logs [COMPONENT]",
+	Args:   cobra.MaximumNArgs(1),
+	PreRun: setup,
+	Run:    runLogs,
+	Short:  "Stream logs of the components of an AppDeployment",
+	Long: strings.TrimSpace(`
+The logs command streams the logs of all replicas of the components belonging
+to an AppDeployment. If a component is provided only its logs are streamed. Each
+line is prefixed with the component and pod name.
+
+By default the AppDeployment of the currently checked out Git commit is used,
+named the same way as by the deploy command. Use the 'name' flag to select
+another AppDeployment.
+
+Logs written in the JSON format used by KubeFox are formatted to be easier to
+read, use the 'raw' flag to disable this.
+`),
+	Example: strings.TrimSpace(`
+# Follow the logs of all components of the current AppDeployment.
+fox logs
+
+# Show the last 10 minutes of logs of 'backend' containing 'error'.
+fox logs backend --follow=false --since 10m --grep error
+
+# Follow the logs of the AppDeployment named 'hello-world-main'.
+fox logs --name hello-world-main
+`),
+}
+
+func init() {
+	logsCmd.Flags().StringVarP(&cfg.Flags.AppDeployment, "name", "d", "", `name of AppDeployment, defaults to <APP NAME>-<GIT REF | GIT COMMIT>`)
+	logsCmd.Flags().BoolVarP(&cfg.Flags.Follow, "follow", "f", true, "follow logs until interrupted")
+	logsCmd.Flags().DurationVarP(&cfg.Flags.Since, "since", "", 0, "only show logs newer than the duration, e.g. 5s, 2m, or 3h")
+	logsCmd.Flags().StringVarP(&cfg.Flags.Grep, "grep", "", "", "only show lines matching the regular expression")
+	logsCmd.Flags().BoolVarP(&cfg.Flags.RawLogs, "raw", "", false, "do not format JSON logs")
+	logsCmd.Flags().StringVarP(&cfg.Flags.Namespace, "namespace", "n", "", "namespace of KubeFox Platform")
+	logsCmd.Flags().StringVarP(&cfg.Flags.Platform, "platform", "p", "", "name of KubeFox Platform to utilize")
+
+	rootCmd.AddCommand(logsCmd)
+}
+
+func runLogs(cmd *cobra.Command, args []string) {
+	checkCommonDeployFlags()
+
+	var comp string
+	if len(args) > 0 {
+		comp = args[0]
+	}
+
+	r, err := repo.New(cfg)
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+	if err := r.Logs(comp); err != nil {
+		log.Fatal("%v", err)
+	}
+}
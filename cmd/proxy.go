@@ -9,10 +9,13 @@
 package cmd
 
 import (
+	"fmt"
+	"slices"
 	"strconv"
 	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/xigxog/fox/internal/errs"
 	"github.com/xigxog/fox/internal/log"
 	"github.com/xigxog/fox/internal/proxy"
 )
@@ -24,20 +27,60 @@ var proxyCmd = &cobra.Command{
 	Run:    runProxy,
 	Short:  "Port forward local port to broker's HTTP server adapter",
 	Long: strings.TrimSpace(`
-The proxy command will inspect the Kubernetes cluster and find an available
-broker to proxy a local port to. This port can then be used to make HTTP
-requests to the broker's HTTP server adapter. This is especially useful during
+The proxy command will inspect the Kubernetes cluster and find the available
+brokers to proxy a local port to. This port can then be used to make HTTP
+requests to the brokers' HTTP server adapter. This is especially useful during
 development and testing.
 
+A connection is kept to every available broker and requests are balanced across
+them, which spreads the load of local load and soak tests. By default brokers
+are used in turn ('round-robin'); set the 'balance' flag to 'least-conn' to send
+each request to the broker with the fewest requests in flight. Brokers are added
+and removed as they become available or go away.
+
 The optional flags 'virtual-env' and 'app-deployment' can be set which will
 automatically inject the values as context to requests sent through the proxy. 
 The context can still be overridden manually by setting the header or query 
 param on the original request.
+
+If the connection to a broker is lost, for example because its pod restarted or
+was replaced during an upgrade of the KubeFox Platform, the proxy reconnects and
+keeps serving on the same local port. If no broker is available requests wait
+for one to become available. Requests that fail because the connection broke are
+retried using another broker if they have no body and use an idempotent method,
+other requests fail with status '502 Bad Gateway'.
+
+Set the 'record' flag to record every request made through the proxy, along with
+its response and timing, to a file in HAR 1.2 format. The file can be attached
+to bug reports or loaded into the network panel of browser dev tools. Bodies
+larger than the 'record-body-limit' flag are truncated. Recorded requests are
+appended to the file every few seconds and when the proxy stops. The values of
+the 'Authorization', 'Proxy-Authorization', 'Cookie' and 'Set-Cookie' headers
+and of cookies are redacted unless the 'record-secrets' flag is set.
+
+Set the 'mirror-deployment' flag to shadow traffic to a candidate AppDeployment,
+such as a branch deploy. Each request is sent as usual and its response returned
+to the caller; once complete a copy of the request is sent in the background to
+the candidate by setting the AppDeployment context. If the candidate's status
+code or body differs from that of the original response a warning is logged.
+Requests with a body larger than 1 MiB are not mirrored. Mirrored requests
+change state just like the original requests, so only mirror to candidates whose
+side effects are safe to repeat.
 `),
 	Example: strings.TrimSpace(`
 # Port forward local port 8080 and wait if no brokers are available.
 fox proxy 8080 --wait 5m
 
+# Port forward local port 8080 and send each request to the least busy broker.
+fox proxy 8080 --balance least-conn
+
+# Port forward local port 8080 and record requests to 'requests.har'.
+fox proxy 8080 --record requests.har
+
+# Port forward local port 8080, send requests to the AppDeployment released to
+# 'my-env', and mirror them to 'my-branch-dep'.
+fox proxy 8080 --virtual-env my-env --mirror-deployment my-branch-dep
+
 # Port forward local port 8080 and inject 'my-env' and 'my-dep' context.
 fox proxy 8080 --virtual-env my-env --app-deployment my-dep
 
@@ -50,6 +93,12 @@ fox proxy 8080 --virtual-env my-env --app-deployment my-dep
 func init() {
 	proxyCmd.Flags().StringVarP(&cfg.Flags.VirtEnv, "virtual-env", "e", "", "environment to add to proxied requests")
 	proxyCmd.Flags().StringVarP(&cfg.Flags.AppDeployment, "app-deployment", "d", "", "deployment to add to proxied requests")
+	proxyCmd.Flags().StringVarP(&cfg.Flags.Balance, "balance", "", proxy.BalanceRoundRobin,
+		fmt.Sprintf(`method used to balance requests across brokers, one of ["%s"]`, strings.Join(proxy.Balancers, `", "`)))
+	proxyCmd.Flags().StringVarP(&cfg.Flags.MirrorDeployment, "mirror-deployment", "", "", "deployment to send a copy of each request to, divergent responses are logged")
+	proxyCmd.Flags().StringVarP(&cfg.Flags.Record, "record", "", "", "record requests and responses to the specified file in HAR format")
+	proxyCmd.Flags().BoolVarP(&cfg.Flags.RecordSecrets, "record-secrets", "", false, "record the values of credential headers and cookies instead of redacting them")
+	proxyCmd.Flags().IntVarP(&cfg.Flags.RecordBodyLimit, "record-body-limit", "", 1024*1024, "maximum bytes of each request and response body to record, larger bodies are truncated")
 
 	addCommonDeployFlags(proxyCmd)
 	rootCmd.AddCommand(proxyCmd)
@@ -58,8 +107,20 @@ func init() {
 func runProxy(cmd *cobra.Command, args []string) {
 	port, err := strconv.Atoi(args[0])
 	if err != nil {
-		log.Fatal("Error invalid local port '%s'.", args[0])
+		log.Fatal("%v", errs.New(errs.TypeUsage, "Error invalid local port '%s'.", args[0]))
+	}
+	if cfg.Flags.RecordBodyLimit < 0 {
+		log.Fatal("%v", errs.New(errs.TypeUsage, "'record-body-limit' flag must be zero or greater."))
+	}
+	if cfg.Flags.MirrorDeployment != "" && cfg.Flags.MirrorDeployment == cfg.Flags.AppDeployment {
+		log.Fatal("%v", errs.New(errs.TypeUsage, "'mirror-deployment' flag must differ from the 'app-deployment' flag."))
+	}
+	if !slices.Contains(proxy.Balancers, cfg.Flags.Balance) {
+		log.Fatal("%v", errs.New(errs.TypeUsage, "Invalid balance method '%s', provide one of: '%s'",
+			cfg.Flags.Balance, strings.Join(proxy.Balancers, "', '")))
 	}
 
-	proxy.Start(port, cfg)
+	if err := proxy.Start(port, cfg); err != nil {
+		log.Fatal("%v", err)
+	}
 }
@@ -10,8 +10,9 @@ package cmd
 
 import (
 	"github.com/spf13/cobra"
+	"github.com/xigxog/fox/internal/errs"
 	"github.com/xigxog/fox/internal/log"
-	"github.com/xigxog/fox/internal/repo"
+	"github.com/xigxog/fox/pkg/fox"
 )
 
 var publishCmd = &cobra.Command{
@@ -30,8 +31,10 @@ func init() {
 	publishCmd.Flags().StringVarP(&cfg.Flags.AppDeployment, "name", "d", "", `name to use for AppDeployment, defaults to <APP NAME>-<VERSION | GIT REF | GIT COMMIT>`)
 	publishCmd.Flags().StringVarP(&cfg.Flags.Version, "version", "s", "", `version to assign to the AppDeployment, making it immutable`)
 	publishCmd.Flags().BoolVarP(&cfg.Flags.CreateTag, "create-tag", "t", false, `create Git tag using the AppDeployment version`)
+	addDirtyFlag(publishCmd)
 	publishCmd.Flags().BoolVarP(&skipPush, "skip-push", "", false, `do not push image after build`)
 	publishCmd.Flags().BoolVarP(&cfg.Flags.SkipDeploy, "skip-deploy", "", false, `do not perform deployment after build`)
+	publishCmd.Flags().IntVarP(&cfg.Flags.Parallel, "parallel", "", 1, `number of components to build and push concurrently`)
 	addCommonBuildFlags(publishCmd)
 	addCommonDeployFlags(publishCmd)
 
@@ -39,17 +42,30 @@ func init() {
 }
 
 func runPublish(cmd *cobra.Command, args []string) error {
-	if skipPush {
-		cfg.Flags.PushImage = false
-		cfg.Flags.SkipDeploy = cfg.Flags.Kind == "" && !cfg.Kind.AlwaysLoad
-	} else {
-		cfg.Flags.PushImage = true
+	if cfg.Flags.Parallel < 1 {
+		log.Fatal("%v", errs.New(errs.TypeUsage, "'parallel' flag must be at least 1."))
 	}
 	if !cfg.Flags.SkipDeploy {
 		checkCommonDeployFlags()
 	}
 
-	d := repo.New(cfg).Publish()
+	c, ctx, cancel := newClient()
+	defer cancel()
+
+	d, err := c.Publish(ctx, fox.PublishOptions{
+		DeployOptions: deployOptions(),
+		SkipPush:      skipPush,
+		SkipDeploy:    cfg.Flags.SkipDeploy,
+		Parallel:      cfg.Flags.Parallel,
+		NoCache:       cfg.Flags.NoCache,
+		Force:         cfg.Flags.ForceBuild,
+	})
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+	if d == nil {
+		return nil
+	}
 
 	// Makes output less cluttered.
 	d.Annotations = nil
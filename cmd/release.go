@@ -9,17 +9,23 @@
 package cmd
 
 import (
+	"fmt"
+	"os"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/xigxog/fox/internal/errs"
 	"github.com/xigxog/fox/internal/log"
 	"github.com/xigxog/fox/internal/repo"
+	"github.com/xigxog/fox/pkg/fox"
 )
 
 var releaseCmd = &cobra.Command{
 	Use:    "release <NAME | COMMIT | SHORT COMMIT | VERSION | TAG | BRANCH>",
-	Args:   cobra.ExactArgs(1),
-	PreRun: setup,
+	Args:   cobra.MaximumNArgs(1),
+	PreRun: setupRelease,
 	Run:    release,
 	Short:  "Release specified AppDeployment and VirtualEnvironment",
 	Long: strings.TrimSpace(`
@@ -32,6 +38,18 @@ The AppDeployment can be identified by its name, commit, short-commit (first 7
 characters), version, Git tag, or Git branch. 🦊 Fox will inspect the Kubernetes
 cluster to find a matching AppDeployment. If more than one AppDeployment is
 found you will be prompted to select the desired AppDeployment.
+
+'fox release history' shows the release history, to release an AppDeployment,
+version, Git tag, or Git branch named 'history' set the 'app-deployment' flag
+instead of passing it as an argument.
+
+Set the 'plan' flag to show what the Release would change without releasing. The
+plan compares the components of the AppDeployment currently released with the
+specified AppDeployment, listing changed hashes and added or removed routes and
+dependencies. Vars required by the AppDeployment that are not set by the
+VirtualEnvironment or its Environment, and any other problems that would prevent
+the Release from being activated, are also listed. The plan is output as a table
+unless the 'output' flag is set to 'json' or 'yaml'.
 `),
 	Example: strings.TrimSpace(`
 # Release the AppDeployment named 'main' using the 'dev' Virtual Environment.
@@ -40,24 +58,130 @@ fox release main --virtual-env dev
 # Release the AppDeployment with version 'v1.2.3' using the 'prod' 
 # VirtualEnvironment.
 fox release v1.2.3 --virtual-env prod
+
+# Show what releasing version 'v1.2.3' to the 'prod' VirtualEnvironment would
+# change.
+fox release v1.2.3 --virtual-env prod --plan
+
+# Release the Git branch named 'history' using the 'dev' VirtualEnvironment.
+fox release --app-deployment history --virtual-env dev
 `),
 }
 
 func init() {
 	releaseCmd.Flags().StringVarP(&cfg.Flags.VirtEnv, "virtual-env", "e", "", "name of VirtualEnvironment to use for Release")
+	releaseCmd.Flags().StringVarP(&cfg.Flags.AppDeployment, "app-deployment", "d", "", "name, commit, version, tag, or branch of AppDeployment to release, in place of the argument")
+	releaseCmd.Flags().BoolVarP(&cfg.Flags.Plan, "plan", "", false, "show the changes the Release would make without releasing")
+	addHistoryLimitFlag(releaseCmd)
 
 	addCommonDeployFlags(releaseCmd)
 
 	releaseCmd.MarkFlagRequired("virtual-env")
 
+	releaseHistoryCmd.Flags().StringVarP(&cfg.Flags.VirtEnv, "virtual-env", "e", "", "name of VirtualEnvironment to show release history of")
+	releaseHistoryCmd.Flags().StringVarP(&cfg.Flags.Namespace, "namespace", "n", "", "namespace of KubeFox Platform")
+	releaseHistoryCmd.Flags().StringVarP(&cfg.Flags.Platform, "platform", "p", "", "name of KubeFox Platform to utilize")
+	releaseHistoryCmd.MarkFlagRequired("virtual-env")
+	releaseHistoryCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return historyNameHint(err)
+	})
+
+	releaseCmd.AddCommand(releaseHistoryCmd)
 	rootCmd.AddCommand(releaseCmd)
 }
 
+func addHistoryLimitFlag(cmd *cobra.Command) {
+	cmd.Flags().IntVarP(&cfg.Flags.HistoryLimit, "history-limit", "", 0, fmt.Sprintf("maximum number of release history records kept for the app, older records are dropped; 0 uses the default of %d", repo.DefaultHistoryLimit))
+}
+
+var releaseHistoryCmd = &cobra.Command{
+	Use: "history",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if err := cobra.NoArgs(cmd, args); err != nil {
+			return historyNameHint(err)
+		}
+		return nil
+	},
+	PreRun: setupTable,
+	Run:    releaseHistory,
+	Short:  "Show the release history of the app in a VirtualEnvironment",
+	Long: strings.TrimSpace(`
+The history command shows every change to the Release of the app in the
+specified VirtualEnvironment made by 🦊 Fox, newest first. Each entry includes
+the AppDeployment, its version and commit, the user that made the change as
+set in their Git config, and when the change was made. Rollbacks are marked.
+
+The history is stored in an annotation on the VirtualEnvironment. The newest 50
+records of each app are kept, set the 'history-limit' flag of 'fox release' or
+'fox rollback' to change how many. Kubernetes limits the total size of an
+object's annotations to 256 KiB, if the history grows beyond 128 KiB the oldest
+records are dropped regardless of the limit.
+
+Output is a table unless the 'output' flag is set to 'json' or 'yaml'.
+`),
+	Example: strings.TrimSpace(`
+# Show the release history of the app in the 'prod' VirtualEnvironment.
+fox release history --virtual-env prod
+
+# Export the release history as JSON.
+fox release history --virtual-env prod -o json > prod-releases.json
+`),
+}
+
+// historyNameHint adds how to release an AppDeployment named 'history' to err,
+// as 'fox release history' runs the history command instead.
+func historyNameHint(err error) error {
+	return fmt.Errorf("%w\nTo release an AppDeployment named 'history' run 'fox release --app-deployment history'.", err)
+}
+
+// setupRelease uses table output for plans.
+func setupRelease(cmd *cobra.Command, args []string) {
+	if cfg.Flags.Plan {
+		setupTable(cmd, args)
+	} else {
+		setup(cmd, args)
+	}
+}
+
 func release(cmd *cobra.Command, args []string) {
-	appDepId := args[0]
+	appDepId := cfg.Flags.AppDeployment
+	switch {
+	case len(args) == 1 && appDepId != "":
+		log.Fatal("%v", errs.New(errs.TypeUsage, "AppDeployment argument cannot be used with 'app-deployment' flag."))
+	case len(args) == 1:
+		appDepId = args[0]
+	case appDepId == "":
+		log.Fatal("%v", errs.New(errs.TypeUsage, "AppDeployment argument or 'app-deployment' flag required."))
+	}
 	checkCommonDeployFlags()
 
-	env := repo.New(cfg).Release(appDepId)
+	c, ctx, cancel := newClient()
+	defer cancel()
+
+	opts := fox.ReleaseOptions{
+		AppDeployment: appDepId,
+		VirtualEnv:    cfg.Flags.VirtEnv,
+		Wait:          cfg.Flags.WaitTime,
+		DryRun:        cfg.Flags.DryRun,
+		HistoryLimit:  cfg.Flags.HistoryLimit,
+	}
+	if cfg.Flags.Plan {
+		plan, err := c.PlanRelease(ctx, opts)
+		if err != nil {
+			log.Fatal("%v", err)
+		}
+		if log.OutputFormat != "table" {
+			log.Marshal(plan)
+		} else {
+			printReleasePlan(plan)
+		}
+		return
+	}
+
+	env, err := c.Release(ctx, opts)
+	if err != nil {
+		log.Fatal("%v", err)
+	}
 
 	// Makes output less cluttered.
 	env.Annotations = nil
@@ -65,3 +189,115 @@ func release(cmd *cobra.Command, args []string) {
 
 	log.Marshal(env)
 }
+
+func releaseHistory(cmd *cobra.Command, args []string) {
+	checkCommonDeployFlags()
+
+	r, err := repo.New(cfg)
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+	history, err := r.ReleaseHistory()
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+	if log.OutputFormat != "table" {
+		log.Marshal(history)
+		return
+	}
+	if len(history) == 0 {
+		log.Printf("No release history recorded for VirtualEnvironment '%s'.\n", cfg.Flags.VirtEnv)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tAPP DEPLOYMENT\tVERSION\tCOMMIT\tUSER\tROLLBACK")
+	for _, rec := range history {
+		commit := rec.Commit
+		if len(commit) > 7 {
+			commit = commit[:7]
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%t\n", rec.Time.Format(time.RFC3339), rec.AppDeployment,
+			dash(rec.Version), dash(commit), dash(rec.User), rec.Rollback)
+	}
+	w.Flush()
+}
+
+func printReleasePlan(plan *repo.ReleasePlan) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VIRTUAL ENV\tAPP\tCURRENT\tCANDIDATE")
+	cur := "-"
+	if plan.Current != nil {
+		cur = planAppDepCell(plan.Current)
+	}
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", plan.VirtualEnvironment, plan.App, cur, planAppDepCell(&plan.Candidate))
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "COMPONENT\tCHANGE\tCURRENT HASH\tCANDIDATE HASH\tROUTES\tDEPENDENCIES")
+	for _, c := range plan.Components {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", c.Name, c.Action, dash(shortHash(c.CurrentHash)),
+			dash(shortHash(c.CandidateHash)), addedRemoved(c.RoutesAdded, c.RoutesRemoved),
+			addedRemoved(c.DependenciesAdded, c.DependenciesRemoved))
+	}
+	fmt.Fprintln(w)
+
+	var routes bool
+	for _, c := range plan.Components {
+		if len(c.RoutesAdded)+len(c.RoutesRemoved) == 0 {
+			continue
+		}
+		if !routes {
+			fmt.Fprintln(w, "COMPONENT\tROUTE")
+			routes = true
+		}
+		for _, rule := range c.RoutesAdded {
+			fmt.Fprintf(w, "%s\t+ %s\n", c.Name, rule)
+		}
+		for _, rule := range c.RoutesRemoved {
+			fmt.Fprintf(w, "%s\t- %s\n", c.Name, rule)
+		}
+	}
+	if routes {
+		fmt.Fprintln(w)
+	}
+
+	if len(plan.MissingVars) > 0 {
+		fmt.Fprintln(w, "MISSING VAR\tCOMPONENTS")
+		for _, v := range plan.MissingVars {
+			fmt.Fprintf(w, "%s\t%s\n", v.Name, strings.Join(v.Components, ", "))
+		}
+		fmt.Fprintln(w)
+	}
+
+	if len(plan.Problems) == 0 {
+		fmt.Fprintln(w, "No problems found, the Release can be activated.")
+	} else {
+		fmt.Fprintln(w, "PROBLEM\tMESSAGE")
+		for _, p := range plan.Problems {
+			fmt.Fprintf(w, "%s\t%s\n", p.Type, p.Message)
+		}
+	}
+	w.Flush()
+}
+
+func planAppDepCell(d *repo.PlanAppDeployment) string {
+	if d.Version != "" {
+		return fmt.Sprintf("%s (%s)", d.Name, d.Version)
+	}
+	return fmt.Sprintf("%s (%s)", d.Name, dash(shortHash(d.Commit)))
+}
+
+// addedRemoved returns the number of added and removed values as '+N -M'.
+func addedRemoved(added, removed []string) string {
+	if len(added)+len(removed) == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("+%d -%d", len(added), len(removed))
+}
+
+func shortHash(h string) string {
+	if len(h) > 7 {
+		return h[:7]
+	}
+	return h
+}
@@ -0,0 +1,118 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/xigxog/fox/internal/errs"
+	"github.com/xigxog/fox/internal/log"
+	"github.com/xigxog/fox/internal/proxy"
+)
+
+var replayCmd = &cobra.Command{
+	Use:    "replay <HAR FILE>",
+	Args:   cobra.ExactArgs(1),
+	PreRun: setupTable,
+	Run:    runReplay,
+	Short:  "Replay requests recorded by the proxy and compare the responses",
+	Long: strings.TrimSpace(`
+The replay command resends the requests of a HAR file, such as one recorded with
+'fox proxy --record', through the brokers' HTTP server adapter and compares the
+status code and body of each response with the recorded response. Requests are
+sent one at a time in the order they were recorded.
+
+Set the 'virtual-env' and 'app-deployment' flags to replace the context of the
+recorded requests, this allows traffic recorded against one AppDeployment to be
+used as a regression check of another. If a flag is not set the context of the
+recorded request is used.
+
+If a recorded response body was truncated only the recorded bytes are compared,
+along with the size of the body. Requests with a truncated body cannot be
+replayed and are reported as failed. Credential headers redacted by the proxy
+are not sent, record with the 'record-secrets' flag to replay them.
+
+Output is a table unless the 'output' flag is set to 'json' or 'yaml'. If any
+response differs or request fails the exit code is that of a 'Mismatch' error.
+`),
+	Example: strings.TrimSpace(`
+# Replay requests recorded with 'fox proxy 8080 --record requests.har' against
+# 'my-new-dep' released to 'my-env'.
+fox replay requests.har --virtual-env my-env --app-deployment my-new-dep
+
+# Replay requests and output the comparison as JSON.
+fox replay requests.har --app-deployment my-new-dep -o json
+`),
+}
+
+func init() {
+	replayCmd.Flags().StringVarP(&cfg.Flags.VirtEnv, "virtual-env", "e", "", "environment to add to replayed requests")
+	replayCmd.Flags().StringVarP(&cfg.Flags.AppDeployment, "app-deployment", "d", "", "deployment to add to replayed requests")
+
+	replayCmd.Flags().StringVarP(&cfg.Flags.Namespace, "namespace", "n", "", "namespace of KubeFox Platform")
+	replayCmd.Flags().StringVarP(&cfg.Flags.Platform, "platform", "p", "", "name of KubeFox Platform to utilize")
+	replayCmd.Flags().DurationVarP(&cfg.Flags.WaitTime, "wait", "", 0, "wait up to the specified time for brokers to be available")
+
+	rootCmd.AddCommand(replayCmd)
+}
+
+func runReplay(cmd *cobra.Command, args []string) {
+	checkCommonDeployFlags()
+
+	res, err := proxy.Replay(args[0], cfg)
+	if err != nil {
+		log.Fatal("Error replaying requests: %v", err)
+	}
+
+	if log.OutputFormat != "table" {
+		log.Marshal(res)
+		// The result is the only document written to stdout, it already
+		// reports the mismatches.
+		log.EnableErrorObject = false
+	} else {
+		printReplayResult(res)
+	}
+
+	if res.Different > 0 || res.Failed > 0 {
+		log.Fatal("%v", errs.New(errs.TypeMismatch, "%d of %d replayed requests did not match the recording",
+			res.Different+res.Failed, res.Requests))
+	}
+}
+
+func printReplayResult(res *proxy.ReplayResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tMETHOD\tPATH\tRECORDED\tREPLAYED\tBODY")
+	for i, e := range res.Entries {
+		replayed, body := strconv.Itoa(e.ReplayedStatus), replayBodyCell(e)
+		if e.Error != "" {
+			replayed, body = "-", "error: "+e.Error
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\t%s\n", i+1, e.Method, e.Path, e.RecordedStatus, replayed, body)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "%d requests replayed, %d matched, %d differed, %d failed.\n",
+		res.Requests, res.Matched, res.Different, res.Failed)
+	w.Flush()
+}
+
+func replayBodyCell(e proxy.ReplayEntry) string {
+	switch {
+	case e.BodyMatch:
+		return "match"
+	case e.RecordedSize != e.ReplayedSize:
+		return fmt.Sprintf("differs at byte %d, %d bytes recorded, %d replayed", e.BodyDiffOffset, e.RecordedSize, e.ReplayedSize)
+	default:
+		return fmt.Sprintf("differs at byte %d", e.BodyDiffOffset)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package cmd
+
+import (
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/xigxog/fox/internal/log"
+	"github.com/xigxog/fox/internal/repo"
+)
+
+var rollbackCmd = &cobra.Command{
+	Use:    "rollback",
+	Args:   cobra.NoArgs,
+	PreRun: setup,
+	Run:    rollback,
+	Short:  "Release the previously released AppDeployment to a VirtualEnvironment",
+	Long: strings.TrimSpace(`
+The rollback command restores the Release of the app in the specified
+VirtualEnvironment to the AppDeployment released before the current one.
+
+Each Release made by 🦊 Fox is recorded in the release history of the
+VirtualEnvironment. If no history has been recorded the VirtualEnvironment's
+Release history is used instead. Previously released AppDeployments that no
+longer exist are skipped. The AppDeployment is validated before it is released
+again.
+`),
+	Example: strings.TrimSpace(`
+# Restore the previous Release of the app in the 'prod' VirtualEnvironment.
+fox rollback --virtual-env prod
+`),
+}
+
+func init() {
+	rollbackCmd.Flags().StringVarP(&cfg.Flags.VirtEnv, "virtual-env", "e", "", "name of VirtualEnvironment to roll back")
+	addHistoryLimitFlag(rollbackCmd)
+
+	addCommonDeployFlags(rollbackCmd)
+
+	rollbackCmd.MarkFlagRequired("virtual-env")
+
+	rootCmd.AddCommand(rollbackCmd)
+}
+
+func rollback(cmd *cobra.Command, args []string) {
+	checkCommonDeployFlags()
+
+	r, err := repo.New(cfg)
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+	env, err := r.Rollback()
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+
+	// Makes output less cluttered.
+	env.Annotations = nil
+	env.ManagedFields = nil
+
+	log.Marshal(env)
+}
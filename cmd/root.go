@@ -9,6 +9,8 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
 	"strings"
 	"time"
 
@@ -17,13 +19,19 @@ import (
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"github.com/xigxog/fox/internal/config"
+	"github.com/xigxog/fox/internal/errs"
 	"github.com/xigxog/fox/internal/log"
+	"github.com/xigxog/fox/internal/utils"
+	"github.com/xigxog/fox/pkg/fox"
 	"github.com/xigxog/kubefox/build"
 	ctrl "sigs.k8s.io/controller-runtime"
 )
 
 var (
 	cfg = &config.Config{}
+
+	// tableOutput is set by commands that support table output.
+	tableOutput bool
 )
 
 var rootCmd = &cobra.Command{
@@ -34,15 +42,22 @@ var rootCmd = &cobra.Command{
 	Long: `
 🦊 Fox is a CLI for interacting with KubeFox. You can use it to build, deploy, 
 and release your KubeFox Apps.
-`,
+
+On failure 🦊 Fox exits with a code identifying the type of error. If the output
+format is JSON the error is also written to stdout as an object containing its
+type, exit code, and message.
+
+` + exitCodes(),
 }
 
 func init() {
 	rootCmd.PersistentFlags().StringVarP(&cfg.Flags.AppPath, "app", "a", "", "path to directory containing KubeFox App")
-	rootCmd.PersistentFlags().StringVarP(&cfg.Flags.OutFormat, "output", "o", "yaml", `output format, one of ["json", "yaml"]`)
+	rootCmd.PersistentFlags().StringVarP(&cfg.Flags.OutFormat, "output", "o", "yaml", `output format, one of ["json", "yaml"], some commands also support "table"`)
 	rootCmd.PersistentFlags().BoolVarP(&cfg.Flags.Info, "info", "i", false, "enable info output")
 	rootCmd.PersistentFlags().BoolVarP(&cfg.Flags.Verbose, "verbose", "v", false, "enable verbose output")
 	rootCmd.PersistentFlags().DurationVarP(&cfg.Flags.Timeout, "timeout", "m", time.Minute*5, `timeout for command`)
+	rootCmd.PersistentFlags().BoolVarP(&cfg.Flags.Yes, "yes", "y", false, `answer yes to all prompts, implies "no-input"`)
+	rootCmd.PersistentFlags().BoolVarP(&cfg.Flags.NoInput, "no-input", "", false, `disable prompts, defaults are used and missing input is an error; set automatically if stdin is not a terminal`)
 
 	rootCmd.PersistentFlags().StringVarP(&cfg.Flags.RegistryAddress, "registry-address", "", "", `address of your container registry`)
 	rootCmd.PersistentFlags().StringVarP(&cfg.Flags.RegistryToken, "registry-token", "", "", `access token for your container registry`)
@@ -62,24 +77,37 @@ func initViper(cmd *cobra.Command, args []string) {
 	})
 }
 
-// TODO add command to apply Env/VirtEnv.
-
 func Execute() {
 	defer log.Logger().Sync()
 
 	err := rootCmd.Execute()
 	if err != nil {
-		log.Fatal("%v", err)
+		log.Fatal("%v", errs.Wrap(errs.TypeUsage, err, ""))
 	}
 }
 
+// exitCodes returns a table of the exit codes used by 🦊 Fox.
+func exitCodes() string {
+	var b strings.Builder
+	b.WriteString("Exit codes:\n\n")
+	for _, t := range errs.Types {
+		fmt.Fprintf(&b, "    %-3d %-13s %s\n", t.ExitCode(), t, t.Description())
+	}
+
+	return b.String()
+}
+
 func setup(cmd *cobra.Command, args []string) {
 	log.OutputFormat = getOutFormat()
 	log.EnableInfo = cfg.Flags.Info
 	log.EnableVerbose = cfg.Flags.Verbose
+	log.EnableErrorObject = log.OutputFormat == "json"
+	cfg.Flags.NoInput = cfg.Flags.NoInput || !utils.IsTerminal()
 	ctrl.SetLogger(logr.Logger{})
 
-	cfg.Load()
+	if err := cfg.Load(); err != nil {
+		log.Fatal("%v", err)
+	}
 	if cfg.Fresh {
 		log.InfoNewline()
 	}
@@ -87,8 +115,48 @@ func setup(cmd *cobra.Command, args []string) {
 	log.VerboseMarshal(build.Info, "")
 }
 
+// newClient returns a fox.Client using the CLI's config and a context that is
+// done once the 'timeout' flag is reached.
+func newClient() (*fox.Client, context.Context, context.CancelFunc) {
+	c, err := fox.New(fox.Options{
+		AppPath:   cfg.Flags.AppPath,
+		Platform:  cfg.Flags.Platform,
+		Namespace: cfg.Flags.Namespace,
+		Registry: fox.Registry{
+			Address:  cfg.Flags.RegistryAddress,
+			Token:    cfg.Flags.RegistryToken,
+			Username: cfg.Flags.RegistryUsername,
+		},
+		Builder:     cfg.Flags.Builder,
+		OCILayout:   cfg.Flags.OCILayout,
+		KindCluster: cfg.Flags.Kind,
+		ConfigFile:  cfg.Path(),
+		Prompt:      !cfg.Flags.NoInput,
+		AssumeYes:   cfg.Flags.Yes,
+	})
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Flags.Timeout)
+
+	return c, ctx, cancel
+}
+
+// setupTable is used in place of setup by commands that support table output.
+// Table output is used by these commands unless another output format is
+// provided.
+func setupTable(cmd *cobra.Command, args []string) {
+	tableOutput = true
+	if !cmd.Flags().Changed("output") {
+		cfg.Flags.OutFormat = "table"
+	}
+	setup(cmd, args)
+}
+
 func getOutFormat() string {
 	switch {
+	case tableOutput && strings.EqualFold(cfg.Flags.OutFormat, "table"):
+		return "table"
 	case strings.EqualFold(cfg.Flags.OutFormat, "yaml") || strings.EqualFold(cfg.Flags.OutFormat, "yml"):
 		return "yaml"
 	case strings.EqualFold(cfg.Flags.OutFormat, "json"):
@@ -96,7 +164,10 @@ func getOutFormat() string {
 	case cfg.Flags.OutFormat == "":
 		return "json"
 	default:
-		log.Fatal("Invalid output format '%s', provide one of: 'json', 'yaml'", cfg.Flags.OutFormat)
+		if tableOutput {
+			log.Fatal("%v", errs.New(errs.TypeUsage, "Invalid output format '%s', provide one of: 'json', 'table', 'yaml'", cfg.Flags.OutFormat))
+		}
+		log.Fatal("%v", errs.New(errs.TypeUsage, "Invalid output format '%s', provide one of: 'json', 'yaml'", cfg.Flags.OutFormat))
 		return ""
 	}
 }
@@ -0,0 +1,118 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/xigxog/fox/internal/log"
+	"github.com/xigxog/fox/internal/repo"
+)
+
+var statusCmd = &cobra.Command{
+	Use:    "status",
+	Args:   cobra.NoArgs,
+	PreRun: setupTable,
+	Run:    runStatus,
+	Short:  "Show the status of the KubeFox App on the cluster",
+	Long: strings.TrimSpace(`
+The status command summarizes the state of the app on the KubeFox Platform. It
+lists the health of the Platform's components, every AppDeployment of the app,
+the VirtualEnvironments releasing each AppDeployment, and the readiness of the
+pods of each component.
+
+Output is a table unless the 'output' flag is set to 'json' or 'yaml'.
+`),
+	Example: strings.TrimSpace(`
+# Show the status of the app in the current directory.
+fox status
+
+# Show the status as JSON.
+fox status -o json
+`),
+}
+
+func init() {
+	statusCmd.Flags().StringVarP(&cfg.Flags.Namespace, "namespace", "n", "", "namespace of KubeFox Platform")
+	statusCmd.Flags().StringVarP(&cfg.Flags.Platform, "platform", "p", "", "name of KubeFox Platform to utilize")
+
+	rootCmd.AddCommand(statusCmd)
+}
+
+func runStatus(cmd *cobra.Command, args []string) {
+	checkCommonDeployFlags()
+
+	r, err := repo.New(cfg)
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+	s, err := r.Status()
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+	if log.OutputFormat != "table" {
+		log.Marshal(s)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PLATFORM\tNAMESPACE\tAVAILABLE")
+	fmt.Fprintf(w, "%s\t%s\t%t\n", s.Platform.Name, s.Platform.Namespace, s.Platform.Available)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "PLATFORM COMPONENT\tREADY")
+	for _, c := range s.Platform.Components {
+		fmt.Fprintf(w, "%s\t%d/%d\n", c.Name, c.Ready, c.Pods)
+	}
+	fmt.Fprintln(w)
+
+	if len(s.AppDeployments) == 0 {
+		fmt.Fprintf(w, "No AppDeployments of app '%s' found.\n", s.App)
+		w.Flush()
+		return
+	}
+
+	fmt.Fprintln(w, "APP DEPLOYMENT\tVERSION\tBRANCH\tTAG\tCOMMIT\tAVAILABLE\tRELEASED BY")
+	for _, d := range s.AppDeployments {
+		commit := d.Commit
+		if len(commit) > 7 {
+			commit = commit[:7]
+		}
+		releases := []string{}
+		for _, r := range d.Releases {
+			if r.Pending {
+				releases = append(releases, r.VirtualEnv+" (pending)")
+			} else {
+				releases = append(releases, r.VirtualEnv)
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%t\t%s\n", d.Name, dash(d.Version), dash(d.Branch),
+			dash(d.Tag), commit, d.Available, dash(strings.Join(releases, ", ")))
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "APP DEPLOYMENT\tCOMPONENT\tHASH\tREADY")
+	for _, d := range s.AppDeployments {
+		for _, c := range d.Components {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d/%d\n", d.Name, c.Name, c.Hash, c.Ready, c.Pods)
+		}
+	}
+	w.Flush()
+}
+
+// dash returns '-' for empty table cells.
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
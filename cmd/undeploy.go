@@ -0,0 +1,73 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package cmd
+
+import (
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/xigxog/fox/internal/log"
+	"github.com/xigxog/fox/internal/repo"
+)
+
+var undeployCmd = &cobra.Command{
+	Use:    "undeploy <NAME | COMMIT | SHORT COMMIT | VERSION | TAG | BRANCH>",
+	Args:   cobra.ExactArgs(1),
+	PreRun: setup,
+	Run:    undeploy,
+	Short:  "Delete specified AppDeployment",
+	Long: strings.TrimSpace(`
+The undeploy command deletes the specified AppDeployment from the KubeFox
+Platform. Components no longer used by any AppDeployment are removed by
+KubeFox.
+
+The AppDeployment can be identified by its name, commit, short-commit (first 7 
+characters), version, Git tag, or Git branch. 🦊 Fox will inspect the Kubernetes
+cluster to find a matching AppDeployment. If more than one AppDeployment is
+found you will be prompted to select the desired AppDeployment.
+
+AppDeployments that are part of the active or pending Release of a
+VirtualEnvironment are not deleted unless the 'force' flag is set.
+`),
+	Example: strings.TrimSpace(`
+# Delete the AppDeployment named 'hello-world-main'.
+fox undeploy hello-world-main
+
+# Delete the AppDeployment with version 'v1.2.3' even if it is released.
+fox undeploy v1.2.3 --force
+`),
+}
+
+func init() {
+	undeployCmd.Flags().BoolVarP(&cfg.Flags.Force, "force", "", false, "delete AppDeployment even if it is released")
+
+	addCommonDeployFlags(undeployCmd)
+
+	rootCmd.AddCommand(undeployCmd)
+}
+
+func undeploy(cmd *cobra.Command, args []string) {
+	appDepId := args[0]
+	checkCommonDeployFlags()
+
+	r, err := repo.New(cfg)
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+	d, err := r.Undeploy(appDepId)
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+
+	// Makes output less cluttered.
+	d.Annotations = nil
+	d.ManagedFields = nil
+
+	log.Marshal(d)
+}
@@ -21,6 +21,7 @@ import (
 	"strings"
 
 	"github.com/cli/oauth/device"
+	"github.com/xigxog/fox/internal/errs"
 	"github.com/xigxog/fox/internal/log"
 	"github.com/xigxog/fox/internal/utils"
 	kfutils "github.com/xigxog/kubefox/utils"
@@ -87,6 +88,14 @@ type ContainerRegistry struct {
 	Username string `json:"username"`
 }
 
+// Prompter returns a Prompter using the 'no-input' and 'yes' flags.
+func (cfg *Config) Prompter() utils.Prompter {
+	return utils.Prompter{
+		NoInput:   cfg.Flags.NoInput || cfg.Flags.Yes,
+		AssumeYes: cfg.Flags.Yes,
+	}
+}
+
 func (cfg *Config) IsRegistryLocal() bool {
 	return strings.HasPrefix(cfg.GetContainerRegistry().Address, LocalRegistry)
 }
@@ -99,23 +108,42 @@ func (cfg *Config) GetContainerRegistry() ContainerRegistry {
 	}
 }
 
-func (cfg *Config) Load() {
+// DefaultPath returns the path of the user's 🦊 Fox config file.
+func DefaultPath() (string, error) {
 	home, err := os.UserHomeDir()
 	if err != nil {
-		log.Fatal("Error accessing user's home directory: %v", err)
+		return "", fmt.Errorf("error accessing user's home directory: %w", err)
 	}
-	cfg.path = filepath.Join(home, ".config/kubefox/config.yaml")
 
-	log.Verbose("Loading Kubefox config from '%s'", cfg.path)
+	return filepath.Join(home, ".config/kubefox/config.yaml"), nil
+}
 
-	b, err := os.ReadFile(cfg.path)
+// Read reads the config file at path. Changes are written back to path by
+// Write.
+func Read(path string) (*Config, error) {
+	cfg := &Config{path: path}
+	if err := cfg.read(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Load reads the user's config file, running setup if it does not exist or is
+// missing the container registry.
+func (cfg *Config) Load() error {
+	var err error
+	if cfg.path, err = DefaultPath(); err != nil {
+		return err
+	}
+
+	log.Verbose("Loading Kubefox config from '%s'", cfg.path)
 
-	if errors.Is(err, fs.ErrNotExist) {
+	if _, err := os.Stat(cfg.path); errors.Is(err, fs.ErrNotExist) {
 		if cfg.Flags.Quickstart || cfg.Flags.GraphQL {
 			cfg.setupQuickstart("kind")
 			cfg.Fresh = true
-			cfg.Write()
-			return
+			return cfg.Write()
 		}
 		log.Info("It looks like this is the first time you are using 🦊 Fox. Welcome!")
 		log.InfoNewline()
@@ -124,56 +152,95 @@ func (cfg *Config) Load() {
 		log.Info("command 'fox config setup'.")
 		log.InfoNewline()
 
-		cfg.Setup()
-	} else if err != nil {
-		log.Fatal("Error reading KubeFox config file '%s': %v", err, cfg.path)
+		if err := cfg.Setup(); err != nil {
+			return err
+		}
 	}
-	if err := yaml.Unmarshal(b, cfg); err != nil {
-		log.Fatal("Error unmarshaling KubeFox config '%s': %v", err, cfg.path)
+	if err := cfg.read(); err != nil {
+		return err
 	}
 	if cfg.ContainerRegistry.Address == "" {
 		log.Info("It looks like the container registry is missing from your config. Rerunning")
 		log.Info("setup to fix the issue.")
 		log.InfoNewline()
 
-		cfg.Setup()
+		return cfg.Setup()
+	}
+
+	return nil
+}
+
+func (cfg *Config) read() error {
+	b, err := os.ReadFile(cfg.path)
+	if err != nil {
+		return errs.Wrap(errs.TypeNotFound, err, "error reading KubeFox config file '%s'", cfg.path)
+	}
+	if err := yaml.Unmarshal(b, cfg); err != nil {
+		return errs.Wrap(errs.TypeValidation, err, "error unmarshaling KubeFox config '%s'", cfg.path)
 	}
+
+	return nil
 }
 
-func (cfg *Config) CleanPaths(defAppToWd bool) {
+// Path returns the path of the config file, empty if the config was not read
+// from a file.
+func (cfg *Config) Path() string {
+	return cfg.path
+}
+
+// CleanPaths resolves the paths of the Git repo and app. The app path is set
+// by the 'app' flag, otherwise it is found by searching for 'app.yaml' from the
+// working dir up to the root of the repo. If defAppToWd is true the working dir
+// is used instead of searching.
+func (cfg *Config) CleanPaths(defAppToWd bool) error {
 	var err error
 
-	repoPath := utils.Find(".git", utils.Wd(), string(filepath.Separator))
+	start, err := utils.Wd()
+	if err != nil {
+		return err
+	}
+	if cfg.Flags.AppPath != "" {
+		if start, err = filepath.Abs(cfg.Flags.AppPath); err != nil {
+			return fmt.Errorf("unable to resolve app path: %w", err)
+		}
+	}
+
+	repoPath := utils.Find(".git", start, string(filepath.Separator))
+	if repoPath == "" {
+		repoPath = start
+	}
 	cfg.RepoPath, err = filepath.Abs(repoPath)
 	if err != nil {
-		log.Fatal("Unable to resolve repo path: %v", err)
+		return fmt.Errorf("unable to resolve repo path: %w", err)
 	}
 
-	var appPath string
-	if cfg.Flags.AppPath == "" {
+	appPath := cfg.Flags.AppPath
+	if appPath == "" {
 		if defAppToWd {
-			appPath = utils.Wd()
+			appPath = start
 		} else {
-			appPath = utils.Find("app.yaml", utils.Wd(), cfg.RepoPath)
+			appPath = utils.Find("app.yaml", start, cfg.RepoPath)
 		}
 	}
+	if appPath == "" {
+		return fmt.Errorf("could not find app definition (app.yaml)")
+	}
 	cfg.AppPath, err = filepath.Abs(appPath)
 	if err != nil {
-		log.Fatal("Unable to resolve app path: %v", err)
+		return fmt.Errorf("unable to resolve app path: %w", err)
 	}
 
 	log.Verbose("Repo path: %s", cfg.RepoPath)
 	log.Verbose("App path: %s", cfg.AppPath)
 
-	if cfg.AppPath == "" {
-		log.Fatal("Could not find app definition (app.yaml).")
-	}
 	if !strings.HasPrefix(cfg.AppPath, cfg.RepoPath) {
-		log.Fatal("The app is not part of the Git repo.")
+		return fmt.Errorf("the app is not part of the Git repo")
 	}
+
+	return nil
 }
 
-func (cfg *Config) Setup() {
+func (cfg *Config) Setup() error {
 	log.Info("Please make sure your workstation has Docker installed (https://docs.docker.com/engine/install)")
 	log.Info("and that KubeFox is installed (https://docs.kubefox.io/install) on your Kubernetes cluster.")
 	log.InfoNewline()
@@ -183,28 +250,36 @@ func (cfg *Config) Setup() {
 	log.Info("🦊 Fox needs a place to store the component images it will build, normally this is")
 	log.Info("a remote container registry. However, if you only want to use KubeFox locally")
 	log.Info("with kind you can skip this step.")
-	kindOnly := utils.YesNoPrompt("Are you only using KubeFox with local kind cluster?", false)
+	kindOnly := cfg.Prompter().YesNo("Are you only using KubeFox with local kind cluster?", false)
 	if kindOnly {
-		name := utils.NamePrompt("kind cluster", "kind", true)
+		name, err := cfg.Prompter().Name("kind cluster", "kind", true, "kind")
+		if err != nil {
+			return err
+		}
 		cfg.setupQuickstart(name)
 		log.InfoNewline()
-		cfg.done()
-		return
+		return cfg.done()
 	}
 	log.InfoNewline()
-	cfg.setupRegistry()
+	if err := cfg.setupRegistry(); err != nil {
+		return err
+	}
 	log.InfoNewline()
-	cfg.done()
+	return cfg.done()
 }
 
-func (cfg *Config) done() {
+func (cfg *Config) done() error {
 	cfg.Fresh = true
-	cfg.Write()
+	if err := cfg.Write(); err != nil {
+		return err
+	}
 	log.InfoNewline()
 
 	log.Info("Congrats, you are ready to use KubeFox!")
 	log.Info("Check out the quickstart for next steps (https://docs.kubefox.io/quickstart/).")
 	log.Info("If you run into any problems please let us know on GitHub (https://github.com/xigxog/kubefox/issues).")
+
+	return nil
 }
 
 func (cfg *Config) setupQuickstart(name string) {
@@ -222,30 +297,35 @@ func (cfg *Config) setupQuickstart(name string) {
 
 }
 
-func (cfg *Config) setupRegistry() {
+func (cfg *Config) setupRegistry() error {
 	if cfg.Flags.RegistryAddress != "" {
 		log.Info("Remote registry information provided. Setting the remote registry %s", cfg.Flags.RegistryAddress)
 		cfg.ContainerRegistry.Address = cfg.Flags.RegistryAddress
 		cfg.ContainerRegistry.Token = cfg.Flags.RegistryToken
 		cfg.ContainerRegistry.Username = cfg.Flags.RegistryUsername
-		return
+		return nil
+	}
+	if cfg.Prompter().NoInput {
+		return errs.New(errs.TypeUsage, "prompts are disabled and no container registry is configured, set the 'registry-address' flag")
 	}
 	log.Info("If you don't already have a container registry 🦊 Fox can help setup the")
 	log.Info("GitHub container registry (ghcr.io).")
-	useGH := utils.YesNoPrompt("Would you like to use ghcr.io?", true)
+	useGH := cfg.Prompter().YesNo("Would you like to use ghcr.io?", true)
 	log.InfoNewline()
 	if useGH {
-		cfg.setupGitHub()
-		return
+		return cfg.setupGitHub()
 	}
 	log.Info("🦊 Fox just needs to know which container registry to use. Please be")
 	log.Info("sure you have permissions to pull and push images to the registry.")
-	cfg.ContainerRegistry.Address = utils.InputPrompt("Enter the container registry endpoint you'd like to use", "", true)
-	cfg.ContainerRegistry.Username = utils.InputPrompt("Enter the container registry username (if required)", "", false)
-	cfg.ContainerRegistry.Token = utils.InputPrompt("Enter the container registry access token or password", "", true)
+	// Prompts are enabled, input is never missing.
+	cfg.ContainerRegistry.Address, _ = cfg.Prompter().Input("Enter the container registry endpoint you'd like to use", "", true, "registry-address")
+	cfg.ContainerRegistry.Username, _ = cfg.Prompter().Input("Enter the container registry username (if required)", "", false, "registry-username")
+	cfg.ContainerRegistry.Token, _ = cfg.Prompter().Input("Enter the container registry access token or password", "", true, "registry-token")
+
+	return nil
 }
 
-func (cfg *Config) setupGitHub() {
+func (cfg *Config) setupGitHub() error {
 	log.Info("🦊 Fox needs to create two access tokens. The first is used by 🦊 Fox and is only")
 	log.Info("stored locally. It allows 🦊 Fox to read your GitHub user and organizations and to")
 	log.Info("push and pull container images to ghcr.io. This information never leaves your")
@@ -255,34 +335,49 @@ func (cfg *Config) setupGitHub() {
 	log.Info("ghcr.io. It is stored locally and as a Secret on your Kubernetes cluster.")
 	log.InfoNewline()
 
+	var err error
 	log.Info("This will create the access token for 🦊 Fox.")
-	cfg.GitHub.Token = getToken([]string{"read:user", "read:org", "read:packages", "write:packages"})
+	if cfg.GitHub.Token, err = getToken([]string{"read:user", "read:org", "read:packages", "write:packages"}); err != nil {
+		return err
+	}
 	log.InfoNewline()
 	log.Info("Next, this will create the access token for Kubernetes to pull images.")
-	cfg.ContainerRegistry.Token = getToken([]string{"read:packages"})
+	if cfg.ContainerRegistry.Token, err = getToken([]string{"read:packages"}); err != nil {
+		return err
+	}
 	log.InfoNewline()
 
 	orgs := []*GitHubOrg{}
-	cfg.callGitHub("GET", "https://api.github.com/user/orgs", &orgs)
-	cfg.callGitHub("GET", "https://api.github.com/user", &cfg.GitHub.User)
+	if err := cfg.callGitHub("GET", "https://api.github.com/user/orgs", &orgs); err != nil {
+		return err
+	}
+	if err := cfg.callGitHub("GET", "https://api.github.com/user", &cfg.GitHub.User); err != nil {
+		return err
+	}
 
 	switch len(orgs) {
 	case 0:
-		log.Error("Oh no, a GitHub organization is required to use GitHub container registry,")
-		log.Fatal("please create one (https://bit.ly/3mNYkh1) before continuing.")
+		return errs.New(errs.TypeNotFound, "a GitHub organization is required to use GitHub container registry, "+
+			"please create one (https://bit.ly/3mNYkh1) before continuing")
 	case 1:
 		cfg.GitHub.Org = *orgs[0]
 		log.InfoNewline()
 	default:
-		cfg.GitHub.Org = *pickOrg(orgs)
+		org, err := cfg.pickOrg(orgs)
+		if err != nil {
+			return err
+		}
+		cfg.GitHub.Org = *org
 	}
 	cfg.ContainerRegistry.Address = fmt.Sprintf("ghcr.io/%s", cfg.GitHub.Org.Name)
+
+	return nil
 }
 
-func getToken(scopes []string) string {
+func getToken(scopes []string) (string, error) {
 	code, err := device.RequestCode(http.DefaultClient, "https://github.com/login/device/code", GitHubClientId, scopes)
 	if err != nil {
-		log.Fatal("%v", err)
+		return "", fmt.Errorf("error requesting GitHub device code: %w", err)
 	}
 	log.Printf("Copy this code '%s', then open '%s' in your browser.\n", code.UserCode, code.VerificationURI)
 	accToken, err := device.Wait(context.Background(), http.DefaultClient, "https://github.com/login/oauth/access_token",
@@ -291,13 +386,17 @@ func getToken(scopes []string) string {
 			DeviceCode: code,
 		})
 	if err != nil {
-		log.Fatal("%v", err)
+		return "", errs.Wrap(errs.TypeUnauthorized, err, "error getting GitHub access token")
 	}
 
-	return accToken.Token
+	return accToken.Token, nil
 }
 
-func pickOrg(orgs []*GitHubOrg) *GitHubOrg {
+func (cfg *Config) pickOrg(orgs []*GitHubOrg) (*GitHubOrg, error) {
+	if cfg.Prompter().NoInput {
+		return nil, errs.New(errs.TypeUsage, "found %d GitHub organizations and prompts are disabled, set the 'registry-address' flag to 'ghcr.io/<ORG>'", len(orgs))
+	}
+
 	for i, o := range orgs {
 		log.Printf("%d. %s\n", i+1, o.Name)
 	}
@@ -309,45 +408,54 @@ func pickOrg(orgs []*GitHubOrg) *GitHubOrg {
 	}
 	i, err := strconv.Atoi(input)
 	if err != nil {
-		return pickOrg(orgs)
+		return cfg.pickOrg(orgs)
 	}
 	i = i - 1
 	if i < 0 || i >= len(orgs) {
-		return pickOrg(orgs)
+		return cfg.pickOrg(orgs)
 	}
 
-	return orgs[i]
+	return orgs[i], nil
 }
 
-func (cfg *Config) Write() {
+// Write writes the config to the file it was read from.
+func (cfg *Config) Write() error {
+	if cfg.path == "" {
+		return fmt.Errorf("error writing KubeFox config: config was not read from a file")
+	}
 	b, err := yaml.Marshal(cfg)
 	if err != nil {
-		log.Fatal("Error marshaling KubeFox config: %v", err)
+		return fmt.Errorf("error marshaling KubeFox config: %w", err)
 	}
 
 	log.VerboseMarshal(cfg, "config:")
 
-	utils.EnsureDirForFile(cfg.path)
+	if err := utils.EnsureDirForFile(cfg.path); err != nil {
+		return err
+	}
 	if err := os.WriteFile(cfg.path, b, 0600); err != nil {
-		log.Fatal("Error writing KubeFox config file: %v", err)
+		return fmt.Errorf("error writing KubeFox config file: %w", err)
 	}
 	log.Info("Configuration successfully written to '%s'.", cfg.path)
+
+	return nil
 }
 
-func (cfg *Config) callGitHub(verb, url string, body any) {
+func (cfg *Config) callGitHub(verb, url string, body any) error {
 	req, err := http.NewRequest(verb, url, nil)
 	if err != nil {
-		log.Fatal("Error calling GitHub: %v", err)
+		return fmt.Errorf("error calling GitHub: %w", err)
 	}
 	req.Header.Set("Accept", "application/vnd.github+json")
 	req.Header.Set("Authorization", "Bearer "+cfg.GitHub.Token)
 	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
 
 	resp, err := http.DefaultClient.Do(req)
-	if resp.Body != nil {
-		defer resp.Body.Close()
+	if err != nil {
+		return errs.Wrap(errs.TypeUnavailable, err, "error calling GitHub")
 	}
-	if resp.StatusCode >= 400 && err == nil {
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
 		ghErr := GitHubError{}
 		if dErr := json.NewDecoder(resp.Body).Decode(&ghErr); dErr != nil {
 			err = dErr
@@ -356,9 +464,11 @@ func (cfg *Config) callGitHub(verb, url string, body any) {
 		}
 	}
 	if err != nil {
-		log.Fatal("Error calling GitHub: %v", err)
+		return fmt.Errorf("error calling GitHub: %w", err)
 	}
 	if err := json.NewDecoder(resp.Body).Decode(body); err != nil {
-		log.Fatal("Error calling GitHub: %v", err)
+		return fmt.Errorf("error calling GitHub: %w", err)
 	}
+
+	return nil
 }
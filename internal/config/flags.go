@@ -22,25 +22,45 @@ type Flags struct {
 
 	DryRun  bool
 	Info    bool
+	NoInput bool
 	Verbose bool
+	Yes     bool
 
 	// flags used by subcommands
-	AppDeployment string
-	Builder       string
-	Kind          string
-	Namespace     string
-	Platform      string
-	Version       string
-	VirtEnv       string
-
-	CreateTag  bool
-	ForceBuild bool
-	Generate   bool
-	GraphQL    bool
-	NoCache    bool
-	PushImage  bool
-	Quickstart bool
-	SkipDeploy bool
-
-	WaitTime time.Duration
+	AppDeployment    string
+	Balance          string
+	Builder          string
+	Grep             string
+	Kind             string
+	MirrorDeployment string
+	Namespace        string
+	OCILayout        string
+	Platform         string
+	Record           string
+	Version          string
+	VirtEnv          string
+
+	CreateTag     bool
+	Diff          bool
+	Dirty         bool
+	Follow        bool
+	Force         bool
+	ForceBuild    bool
+	Generate      bool
+	GraphQL       bool
+	NoCache       bool
+	Plan          bool
+	PushImage     bool
+	Quickstart    bool
+	RawLogs       bool
+	RecordSecrets bool
+	SkipDeploy    bool
+
+	HistoryLimit    int
+	Keep            int
+	Parallel        int
+	RecordBodyLimit int
+	OlderThan       time.Duration
+	Since           time.Duration
+	WaitTime        time.Duration
 }
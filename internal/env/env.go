@@ -0,0 +1,359 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package env
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/xigxog/fox/internal/config"
+	"github.com/xigxog/fox/internal/errs"
+	"github.com/xigxog/fox/internal/kubernetes"
+	"github.com/xigxog/fox/internal/log"
+	"github.com/xigxog/kubefox/api"
+	"github.com/xigxog/kubefox/api/kubernetes/v1alpha1"
+	"github.com/xigxog/kubefox/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	KindEnvironment = "Environment"
+	KindVirtualEnv  = "VirtualEnvironment"
+)
+
+// DefaultDir is the directory, relative to the app, containing the
+// Environments and VirtualEnvironments created by 'fox init'.
+var DefaultDir = filepath.Join("hack", "environments")
+
+type manager struct {
+	cfg  *config.Config
+	kind string
+	k8s  *kubernetes.Client
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// New returns a manager for resources of kind, either KindEnvironment or
+// KindVirtualEnv.
+func New(cfg *config.Config, kind string) (*manager, error) {
+	if kind != KindEnvironment && kind != KindVirtualEnv {
+		return nil, fmt.Errorf("unsupported kind '%s'", kind)
+	}
+
+	k8s, err := kubernetes.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Flags.Timeout)
+
+	return &manager{
+		cfg:    cfg,
+		kind:   kind,
+		k8s:    k8s,
+		ctx:    ctx,
+		cancel: cancel,
+	}, nil
+}
+
+// Apply reads the resources of the manager's kind from the YAML or JSON files
+// at paths and server-side applies them. Directories are searched for files
+// ending in '.yaml', '.yml', or '.json'. Documents of other kinds are skipped.
+// All resources are validated before any are applied.
+func (m *manager) Apply(paths []string) ([]client.Object, error) {
+	objs := []client.Object{}
+	for _, path := range paths {
+		files, err := findFiles(path)
+		if err != nil {
+			return nil, errs.Wrap(errs.TypeNotFound, err, "error reading '%s'", path)
+		}
+		for _, file := range files {
+			found, err := m.readFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("error reading '%s': %w", file, err)
+			}
+			objs = append(objs, found...)
+		}
+	}
+	if len(objs) == 0 {
+		return nil, errs.New(errs.TypeNotFound, "no %ss found in %s", m.kind, strings.Join(paths, ", "))
+	}
+
+	if m.kind == KindVirtualEnv {
+		p, err := m.k8s.GetPlatform(m.ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objs {
+			if ns := obj.GetNamespace(); ns != "" && ns != p.Namespace {
+				return nil, errs.New(errs.TypeValidation,
+					"VirtualEnvironment '%s' has namespace '%s' but KubeFox Platform '%s' is in namespace '%s'",
+					obj.GetName(), ns, p.Name, p.Namespace)
+			}
+			obj.SetNamespace(p.Namespace)
+		}
+	}
+
+	for _, obj := range objs {
+		log.Info("Applying %s '%s'.", m.kind, obj.GetName())
+		if err := m.k8s.Apply(m.ctx, obj); err != nil {
+			return nil, fmt.Errorf("error applying %s '%s': %w", m.kind, obj.GetName(), err)
+		}
+		// TypeMeta is cleared when the response is decoded.
+		setTypeMeta(obj, m.kind)
+	}
+
+	return objs, nil
+}
+
+// List returns the resources of the manager's kind. VirtualEnvironments are
+// listed from the namespace of the KubeFox Platform.
+func (m *manager) List() ([]client.Object, error) {
+	var (
+		list client.ObjectList
+		opts []client.ListOption
+	)
+	switch m.kind {
+	case KindEnvironment:
+		list = &v1alpha1.EnvironmentList{}
+	case KindVirtualEnv:
+		p, err := m.k8s.GetPlatform(m.ctx)
+		if err != nil {
+			return nil, err
+		}
+		list = &v1alpha1.VirtualEnvironmentList{}
+		opts = append(opts, client.InNamespace(p.Namespace))
+	}
+	if err := m.k8s.List(m.ctx, list, opts...); err != nil {
+		return nil, fmt.Errorf("error listing %ss: %w", m.kind, err)
+	}
+
+	objs := []client.Object{}
+	switch l := list.(type) {
+	case *v1alpha1.EnvironmentList:
+		for i := range l.Items {
+			objs = append(objs, &l.Items[i])
+		}
+	case *v1alpha1.VirtualEnvironmentList:
+		for i := range l.Items {
+			objs = append(objs, &l.Items[i])
+		}
+	}
+	for _, obj := range objs {
+		setTypeMeta(obj, m.kind)
+	}
+	sort.Slice(objs, func(i, j int) bool {
+		return objs[i].GetName() < objs[j].GetName()
+	})
+
+	return objs, nil
+}
+
+// Get returns the resource of the manager's kind with the given name.
+func (m *manager) Get(name string) (client.Object, error) {
+	obj := m.newObject()
+	obj.SetName(name)
+	if m.kind == KindVirtualEnv {
+		p, err := m.k8s.GetPlatform(m.ctx)
+		if err != nil {
+			return nil, err
+		}
+		obj.SetNamespace(p.Namespace)
+	}
+	if err := m.k8s.Get(m.ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+		return nil, fmt.Errorf("error getting %s '%s': %w", m.kind, name, err)
+	}
+	setTypeMeta(obj, m.kind)
+
+	return obj, nil
+}
+
+// Delete deletes the resource of the manager's kind with the given name and
+// returns it.
+func (m *manager) Delete(name string) (client.Object, error) {
+	obj, err := m.Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info("Deleting %s '%s'.", m.kind, name)
+	if err := m.k8s.Delete(m.ctx, obj); err != nil {
+		return nil, fmt.Errorf("error deleting %s '%s': %w", m.kind, name, err)
+	}
+
+	return obj, nil
+}
+
+func (m *manager) newObject() client.Object {
+	switch m.kind {
+	case KindEnvironment:
+		return &v1alpha1.Environment{}
+	default:
+		return &v1alpha1.VirtualEnvironment{}
+	}
+}
+
+// readFile decodes and validates all documents of the manager's kind in the
+// file.
+func (m *manager) readFile(file string) ([]client.Object, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	objs := []client.Object{}
+	reader := k8syaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(b)))
+	for i := 1; ; i++ {
+		doc, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+
+		typeMeta := &metav1.TypeMeta{}
+		if err := yaml.Unmarshal(doc, typeMeta); err != nil {
+			return nil, errs.Wrap(errs.TypeValidation, err, "document %d", i)
+		}
+		if typeMeta.Kind != m.kind {
+			log.Verbose("Skipping document %d of '%s' with kind '%s'.", i, file, typeMeta.Kind)
+			continue
+		}
+		if typeMeta.APIVersion != v1alpha1.GroupVersion.Identifier() {
+			return nil, errs.New(errs.TypeValidation, "document %d: unsupported apiVersion '%s', expected '%s'",
+				i, typeMeta.APIVersion, v1alpha1.GroupVersion.Identifier())
+		}
+
+		obj := m.newObject()
+		if err := yaml.UnmarshalStrict(doc, obj); err != nil {
+			return nil, errs.Wrap(errs.TypeValidation, err, "document %d", i)
+		}
+		if err := validate(obj); err != nil {
+			return nil, errs.Wrap(errs.TypeValidation, err, "%s '%s'", m.kind, obj.GetName())
+		}
+		log.Verbose("Read %s '%s' from '%s'.", m.kind, obj.GetName(), file)
+
+		objs = append(objs, obj)
+	}
+
+	return objs, nil
+}
+
+// validate checks the fields that are validated by the KubeFox CRDs so
+// problems are reported before anything is applied.
+func validate(obj client.Object) error {
+	if !utils.IsValidName(obj.GetName()) {
+		return fmt.Errorf("invalid name, valid names contain only lowercase alpha-numeric characters and dashes")
+	}
+
+	switch o := obj.(type) {
+	case *v1alpha1.Environment:
+		p := o.Spec.ReleasePolicy
+		if err := validateReleaseType(p.Type); err != nil {
+			return err
+		}
+		if err := validateDeadline(p.ActivationDeadlineSeconds); err != nil {
+			return err
+		}
+
+	case *v1alpha1.VirtualEnvironment:
+		if o.Spec.Environment == "" {
+			return fmt.Errorf("spec.environment is required")
+		}
+		if !utils.IsValidName(o.Spec.Environment) {
+			return fmt.Errorf("invalid spec.environment '%s'", o.Spec.Environment)
+		}
+		if rel := o.Spec.Release; rel != nil {
+			if len(rel.Apps) == 0 {
+				return fmt.Errorf("spec.release.apps must contain at least one app")
+			}
+			for app, a := range rel.Apps {
+				if a.AppDeployment == "" {
+					return fmt.Errorf("spec.release.apps.%s.appDeployment is required", app)
+				}
+			}
+		}
+		if p := o.Spec.ReleasePolicy; p != nil {
+			if err := validateReleaseType(p.Type); err != nil {
+				return err
+			}
+			if err := validateDeadline(p.ActivationDeadlineSeconds); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateReleaseType(t api.ReleaseType) error {
+	switch t {
+	case "", api.ReleaseTypeStable, api.ReleaseTypeTesting:
+		return nil
+	default:
+		return fmt.Errorf("invalid releasePolicy.type '%s', must be one of '%s', '%s'",
+			t, api.ReleaseTypeStable, api.ReleaseTypeTesting)
+	}
+}
+
+func validateDeadline(secs *uint) error {
+	if secs != nil && *secs < 3 {
+		return fmt.Errorf("releasePolicy.activationDeadlineSeconds must be at least 3")
+	}
+
+	return nil
+}
+
+// findFiles returns path if it is a file or the YAML and JSON files in path,
+// sorted by name, if it is a directory.
+func findFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		switch filepath.Ext(e.Name()) {
+		case ".yaml", ".yml", ".json":
+			files = append(files, filepath.Join(path, e.Name()))
+		}
+	}
+
+	return files, nil
+}
+
+func setTypeMeta(obj client.Object, kind string) {
+	obj.GetObjectKind().SetGroupVersionKind(v1alpha1.GroupVersion.WithKind(kind))
+}
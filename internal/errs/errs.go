@@ -0,0 +1,202 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+// Package errs contains the typed errors returned by 🦊 Fox. Each Type maps to
+// a stable exit code.
+package errs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/xigxog/kubefox/core"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+type Type string
+
+const (
+	TypeGeneral      Type = "General"
+	TypeUsage        Type = "Usage"
+	TypeNotFound     Type = "NotFound"
+	TypeMissingImage Type = "MissingImage"
+	TypeValidation   Type = "Validation"
+	TypeUnauthorized Type = "Unauthorized"
+	TypeDirty        Type = "Dirty"
+	TypeTimeout      Type = "Timeout"
+	TypeUnavailable  Type = "Unavailable"
+	TypeBuildFailed  Type = "BuildFailed"
+	TypeAborted      Type = "Aborted"
+	TypeNotReady     Type = "NotReady"
+	TypeMismatch     Type = "Mismatch"
+)
+
+// Types lists all Types in order of exit code.
+var Types = []Type{
+	TypeGeneral,
+	TypeUsage,
+	TypeNotFound,
+	TypeMissingImage,
+	TypeValidation,
+	TypeUnauthorized,
+	TypeDirty,
+	TypeTimeout,
+	TypeUnavailable,
+	TypeBuildFailed,
+	TypeAborted,
+	TypeNotReady,
+	TypeMismatch,
+}
+
+var descriptions = map[Type]string{
+	TypeGeneral:      "unclassified error",
+	TypeUsage:        "invalid flags, arguments, or missing input",
+	TypeNotFound:     "resource not found",
+	TypeMissingImage: "component image missing",
+	TypeValidation:   "validation problems",
+	TypeUnauthorized: "authentication or authorization failure",
+	TypeDirty:        "uncommitted changes",
+	TypeTimeout:      "operation timed out",
+	TypeUnavailable:  "cluster or registry unreachable",
+	TypeBuildFailed:  "component build failed",
+	TypeAborted:      "operation aborted",
+	TypeNotReady:     "component failed to become ready",
+	TypeMismatch:     "replayed responses differ from recorded responses",
+}
+
+// Error is an error of a specific Type, optionally wrapping its cause.
+type Error struct {
+	Type    Type
+	Message string
+	Err     error
+}
+
+// Object is the structured form of an error written when JSON output is used.
+type Object struct {
+	Type     Type   `json:"type"`
+	ExitCode int    `json:"exitCode"`
+	Message  string `json:"message"`
+}
+
+func New(t Type, format string, v ...any) *Error {
+	return &Error{Type: t, Message: fmt.Sprintf(format, v...)}
+}
+
+func Wrap(t Type, err error, format string, v ...any) *Error {
+	return &Error{Type: t, Message: fmt.Sprintf(format, v...), Err: err}
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.Err != nil && e.Message == "":
+		return e.Err.Error()
+	case e.Err != nil:
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ExitCode returns the exit code used for errors of the Type.
+func (t Type) ExitCode() int {
+	for i, typ := range Types {
+		if typ == t {
+			return i + 1
+		}
+	}
+	return 1
+}
+
+// Description returns a short description of the Type.
+func (t Type) Description() string {
+	return descriptions[t]
+}
+
+// TypeOf returns the Type of err. If err does not wrap an Error the Type is
+// determined from well known errors of the Kubernetes client, container
+// registry client, and network. TypeGeneral is returned if the Type cannot be
+// determined.
+func TypeOf(err error) Type {
+	if err == nil {
+		return TypeGeneral
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Type
+	}
+
+	var kfErr *core.Err
+	if errors.As(err, &kfErr) && kfErr.Code() == core.CodeNotFound {
+		return TypeNotFound
+	}
+
+	var tErr *transport.Error
+	if errors.As(err, &tErr) {
+		switch tErr.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return TypeUnauthorized
+		case http.StatusNotFound:
+			return TypeNotFound
+		}
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded),
+		apierrors.IsTimeout(err), apierrors.IsServerTimeout(err):
+		return TypeTimeout
+	case apierrors.IsNotFound(err):
+		return TypeNotFound
+	case apierrors.IsUnauthorized(err), apierrors.IsForbidden(err):
+		return TypeUnauthorized
+	case apierrors.IsInvalid(err), apierrors.IsBadRequest(err):
+		return TypeValidation
+	case apierrors.IsServiceUnavailable(err), errors.Is(err, syscall.ECONNREFUSED):
+		return TypeUnavailable
+	}
+
+	var netErr *net.OpError
+	if errors.As(err, &netErr) {
+		return TypeUnavailable
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return TypeUnavailable
+	}
+
+	return TypeGeneral
+}
+
+// ExitCode returns the exit code for err.
+func ExitCode(err error) int {
+	return TypeOf(err).ExitCode()
+}
+
+// ToObject returns the structured form of err. If msg is not empty it is
+// used as the message in place of err's.
+func ToObject(err error, msg string) *Object {
+	if msg == "" && err != nil {
+		msg = err.Error()
+	}
+	t := TypeOf(err)
+
+	return &Object{
+		Type:     t,
+		ExitCode: t.ExitCode(),
+		Message:  msg,
+	}
+}
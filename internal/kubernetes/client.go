@@ -18,20 +18,25 @@ import (
 
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
+	"slices"
 	"strconv"
-	"time"
+	"sync"
 
 	"github.com/xigxog/fox/internal/config"
+	"github.com/xigxog/fox/internal/errs"
 	"github.com/xigxog/fox/internal/log"
-	"github.com/xigxog/fox/internal/utils"
 	"github.com/xigxog/kubefox/api"
 	"github.com/xigxog/kubefox/api/kubernetes/v1alpha1"
 	"github.com/xigxog/kubefox/k8s"
+	kfutils "github.com/xigxog/kubefox/utils"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/tools/portforward"
 	"k8s.io/client-go/transport/spdy"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -44,7 +49,8 @@ var (
 type Client struct {
 	*k8s.Client
 
-	cfg *config.Config
+	watcher client.WithWatch
+	cfg     *config.Config
 }
 
 type PortForwardRequest struct {
@@ -57,22 +63,32 @@ type PortForwardRequest struct {
 
 type PortForward struct {
 	LocalPort int32
-
-	pfer    *portforward.PortForwarder
-	stopCh  chan struct{}
-	readyCh chan struct{}
+	Pod       string
+
+	pfer     *portforward.PortForwarder
+	stopCh   chan struct{}
+	readyCh  chan struct{}
+	doneCh   chan struct{}
+	stopOnce sync.Once
+	err      error
 }
 
-func NewClient(cfg *config.Config) *Client {
+func NewClient(cfg *config.Config) (*Client, error) {
 	cli, err := k8s.NewClient("fox")
 	if err != nil {
-		log.Fatal("Error creating Kubernetes client: %v", err)
+		return nil, fmt.Errorf("unable to create Kubernetes client: %w", err)
 	}
 
-	return &Client{
-		Client: cli,
-		cfg:    cfg,
+	watcher, err := client.NewWithWatch(cli.RestConfig, client.Options{Scheme: scheme.Scheme})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create Kubernetes client: %w", err)
 	}
+
+	return &Client{
+		Client:  cli,
+		watcher: watcher,
+		cfg:     cfg,
+	}, nil
 }
 
 func (c *Client) Create(ctx context.Context, obj client.Object) error {
@@ -83,6 +99,14 @@ func (c *Client) Create(ctx context.Context, obj client.Object) error {
 	return c.Client.Create(ctx, obj, opts...)
 }
 
+func (c *Client) Delete(ctx context.Context, obj client.Object) error {
+	opts := []client.DeleteOption{}
+	if c.cfg.Flags.DryRun {
+		opts = append(opts, client.DryRunAll)
+	}
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
 func (c *Client) Apply(ctx context.Context, obj client.Object) error {
 	opts := []client.PatchOption{}
 	if c.cfg.Flags.DryRun {
@@ -121,7 +145,7 @@ func (c *Client) ListPlatforms(ctx context.Context) ([]v1alpha1.Platform, error)
 	return pList.Items, nil
 }
 
-func (c *Client) GetPlatform(ctx context.Context) *v1alpha1.Platform {
+func (c *Client) GetPlatform(ctx context.Context) (*v1alpha1.Platform, error) {
 	nn := client.ObjectKey{
 		Namespace: c.cfg.Flags.Namespace,
 		Name:      c.cfg.Flags.Platform,
@@ -132,47 +156,51 @@ func (c *Client) GetPlatform(ctx context.Context) *v1alpha1.Platform {
 
 	}
 
-	platform := &v1alpha1.Platform{}
 	if nn.Name == "" {
-		platform = c.pickPlatform(ctx)
-
-	} else {
-		if err := c.Get(ctx, nn, platform); err != nil {
-			if apierrors.IsNotFound(err) {
-				platform = c.pickPlatform(ctx)
-			} else {
-				log.Fatal("Unable to get KubeFox Platform: %v", err)
-			}
-		}
+		return c.pickPlatform(ctx)
+	}
+
+	platform := &v1alpha1.Platform{}
+	if err := c.Get(ctx, nn, platform); apierrors.IsNotFound(err) {
+		return c.pickPlatform(ctx)
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to get KubeFox Platform: %w", err)
 	}
 
-	return platform
+	return platform, nil
 }
 
-func (c *Client) pickPlatform(ctx context.Context) *v1alpha1.Platform {
+func (c *Client) pickPlatform(ctx context.Context) (*v1alpha1.Platform, error) {
 	pList, err := c.ListPlatforms(ctx)
 	if err != nil {
-		log.Fatal("%v", err)
+		return nil, err
 	}
 
 	switch len(pList) {
 	case 0:
-		if !c.cfg.Flags.Info {
+		if !log.EnableInfo {
 			context := c.Client.KubeConfig.CurrentContext
 			cluster := c.Client.KubeConfig.Contexts[context].Cluster
 			log.Warn("No KubeFox Platforms found on the current cluster '%s'.", cluster)
 		}
 		log.Info("You need to have a KubeFox Platform instance running to deploy your components.")
 		log.Info("Don't worry, 🦊 Fox can create one for you.")
-		if utils.YesNoPrompt("Would you like to create a KubeFox Platform?", true) {
+		prompter := c.cfg.Prompter()
+		if prompter.NoInput && !prompter.AssumeYes {
+			return nil, errs.New(errs.TypeNotFound, "no KubeFox Platform found, create one or set the 'yes' flag to have 🦊 Fox create it")
+		}
+		if prompter.YesNo("Would you like to create a KubeFox Platform?", true) {
 			return c.createPlatformPrompt(ctx)
 		} else {
-			log.Fatal("Error you must create a KubeFox Platform before deploying components.")
+			return nil, errs.New(errs.TypeNotFound, "you must create a KubeFox Platform before deploying components")
 		}
 	case 1:
-		return &pList[0]
+		return &pList[0], nil
 	}
 
+	if c.cfg.Prompter().NoInput {
+		return nil, errs.New(errs.TypeUsage, "found %d KubeFox Platforms, set the 'platform' and 'namespace' flags to select one", len(pList))
+	}
 	for i, p := range pList {
 		log.Printf("%d. %s/%s\n", i+1, p.Namespace, p.Name)
 	}
@@ -191,27 +219,35 @@ func (c *Client) pickPlatform(ctx context.Context) *v1alpha1.Platform {
 
 	p := &pList[i]
 	if len(pList) > 1 {
-		if utils.YesNoPrompt("Remember selected KubeFox Platform?", true) {
+		if c.cfg.Prompter().YesNo("Remember selected KubeFox Platform?", true) {
 			c.cfg.KubeFox.Namespace = p.Namespace
 			c.cfg.KubeFox.Platform = p.Name
-			c.cfg.Write()
+			if err := c.cfg.Write(); err != nil {
+				log.Warn("Unable to remember selected KubeFox Platform: %v", err)
+			}
 		}
 	}
 	log.InfoNewline()
 
-	return p
+	return p, nil
 }
 
-func (c *Client) createPlatformPrompt(ctx context.Context) *v1alpha1.Platform {
-	name := utils.NamePrompt("KubeFox Platform", "", true)
-	namespace := utils.InputPrompt("Enter the Kubernetes namespace of the KubeFox Platform",
-		fmt.Sprintf("kubefox-%s", name), true)
+func (c *Client) createPlatformPrompt(ctx context.Context) (*v1alpha1.Platform, error) {
+	name, err := c.cfg.Prompter().Name("KubeFox Platform", c.cfg.Flags.Platform, true, "platform")
+	if err != nil {
+		return nil, err
+	}
+	namespace, err := c.cfg.Prompter().Input("Enter the Kubernetes namespace of the KubeFox Platform",
+		kfutils.First(c.cfg.Flags.Namespace, fmt.Sprintf("kubefox-%s", name)), true, "namespace")
+	if err != nil {
+		return nil, err
+	}
 	log.InfoNewline()
 
 	return c.CreatePlatform(ctx, namespace, name)
 }
 
-func (c *Client) CreatePlatform(ctx context.Context, namespace, name string) *v1alpha1.Platform {
+func (c *Client) CreatePlatform(ctx context.Context, namespace, name string) (*v1alpha1.Platform, error) {
 	ns := &corev1.Namespace{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: corev1.SchemeGroupVersion.Identifier(),
@@ -222,7 +258,7 @@ func (c *Client) CreatePlatform(ctx context.Context, namespace, name string) *v1
 		},
 	}
 	if err := c.Apply(ctx, ns); err != nil {
-		log.Fatal("%v", err)
+		return nil, fmt.Errorf("unable to create namespace: %w", err)
 	}
 
 	p := &v1alpha1.Platform{
@@ -236,76 +272,112 @@ func (c *Client) CreatePlatform(ctx context.Context, namespace, name string) *v1
 		},
 	}
 	if err := c.Apply(ctx, p); err != nil {
-		log.Fatal("%v", err)
+		return nil, fmt.Errorf("unable to create KubeFox Platform: %w", err)
 	}
 
-	return p
+	return p, nil
 }
 
-func (c *Client) WaitPlatformReady(waitTime time.Duration, p *v1alpha1.Platform, spec *v1alpha1.AppDeploymentSpec) {
-	if waitTime <= 0 {
-		return
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), waitTime)
-	defer cancel()
-
+// WaitPlatformReady waits until ctx is done for the components of the Platform
+// and, if provided, the components of spec to be ready.
+func (c *Client) WaitPlatformReady(ctx context.Context, p *v1alpha1.Platform, spec *v1alpha1.AppDeploymentSpec) error {
 	log.Info("Waiting for KubeFox Platform '%s' to be ready...", p.Name)
-	if err := c.WaitPodReady(ctx, p, api.PlatformComponentNATS, ""); err != nil {
-		log.Fatal("Error while waiting: %v", err)
-	}
-	if err := c.WaitPodReady(ctx, p, api.PlatformComponentBroker, ""); err != nil {
-		log.Fatal("Error while waiting: %v", err)
-	}
-	if err := c.WaitPodReady(ctx, p, api.PlatformComponentHTTPSrv, ""); err != nil {
-		log.Fatal("Error while waiting: %v", err)
+	for _, n := range []string{api.PlatformComponentNATS, api.PlatformComponentBroker, api.PlatformComponentHTTPSrv} {
+		if err := c.WaitPodReady(ctx, p, n, ""); err != nil {
+			return err
+		}
 	}
 
 	if spec != nil {
 		for n, comp := range spec.Components {
 			log.Info("Waiting for component '%s' to be ready...", n)
 			if err := c.WaitPodReady(ctx, p, n, comp.Hash); err != nil {
-				log.Fatal("Error while waiting: %v", err)
+				return err
 			}
 		}
 	}
+
+	return nil
+}
+
+// ListPlatformPods returns all pods belonging to the Platform, including the
+// pods of App components.
+func (c *Client) ListPlatformPods(ctx context.Context, p *v1alpha1.Platform) ([]corev1.Pod, error) {
+	l := &corev1.PodList{}
+	if err := c.List(ctx, l, client.InNamespace(p.Namespace), client.MatchingLabels{
+		api.LabelK8sPlatform: p.Name,
+	}); err != nil {
+		return nil, fmt.Errorf("unable to list pods: %w", err)
+	}
+
+	return l.Items, nil
 }
 
-func (c *Client) WaitPodReady(ctx context.Context, p *v1alpha1.Platform, comp, hash string) error {
-	log.Verbose("Waiting for component '%s' with hash '%s' to be ready...", comp, hash)
+// IsPodReady returns true if the pod has started and all of its containers are
+// ready.
+func IsPodReady(pod *corev1.Pod) bool {
+	if len(pod.Status.ContainerStatuses) == 0 {
+		return false
+	}
+	for _, c := range pod.Status.ContainerStatuses {
+		if !c.Ready {
+			return false
+		}
+	}
+
+	return true
+}
 
-	hasLabels := client.MatchingLabels{
+// ReadyPods returns the names of the component's ready pods, sorted by name.
+// Pods being deleted are not included.
+func (c *Client) ReadyPods(ctx context.Context, p *v1alpha1.Platform, comp string) ([]string, error) {
+	l := &corev1.PodList{}
+	if err := c.List(ctx, l, client.InNamespace(p.Namespace), client.MatchingLabels{
 		api.LabelK8sComponent: comp,
 		api.LabelK8sPlatform:  p.Name,
-	}
-	if hash != "" {
-		hasLabels[api.LabelK8sComponentHash] = hash
+	}); err != nil {
+		return nil, fmt.Errorf("unable to list pods: %w", err)
 	}
 
-	l := &corev1.PodList{}
-	if err := c.List(ctx, l, client.InNamespace(p.Namespace), hasLabels); err != nil {
-		return fmt.Errorf("unable to list pods: %w", err)
+	names := []string{}
+	for _, pod := range l.Items {
+		if pod.DeletionTimestamp == nil && IsPodReady(&pod) {
+			names = append(names, pod.Name)
+		}
 	}
+	slices.Sort(names)
 
-	ready := len(l.Items) > 0
-	for _, p := range l.Items {
-		for _, c := range p.Status.ContainerStatuses {
-			if !c.Ready {
-				ready = false
-				break
+	return names, nil
+}
+
+// WatchReadyPods calls onChange with the names of the component's ready pods,
+// sorted by name, when first called and each time they change until ctx is
+// done. Pods being deleted are not included.
+func (c *Client) WatchReadyPods(ctx context.Context, p *v1alpha1.Platform, comp string, onChange func([]string)) error {
+	var last []string
+	err := c.watchObjects(ctx, &corev1.PodList{}, func(objs []client.Object) (bool, error) {
+		names := []string{}
+		for _, obj := range objs {
+			pod := obj.(*corev1.Pod)
+			if pod.DeletionTimestamp == nil && IsPodReady(pod) {
+				names = append(names, pod.Name)
 			}
 		}
-	}
-	if !ready {
-		if ctx.Err() != nil {
-			return ctx.Err()
+		if last == nil || !slices.Equal(names, last) {
+			last = names
+			onChange(names)
 		}
-		time.Sleep(time.Second * 3)
-		return c.WaitPodReady(ctx, p, comp, hash)
+
+		return false, nil
+	}, client.InNamespace(p.Namespace), client.MatchingLabels{
+		api.LabelK8sComponent: comp,
+		api.LabelK8sPlatform:  p.Name,
+	})
+	if ctx.Err() != nil {
+		return nil
 	}
-	log.Verbose("Component '%s' with commit '%s' is ready.", comp, hash)
 
-	return nil
+	return err
 }
 
 func (c *Client) PortForward(ctx context.Context, req *PortForwardRequest) (*PortForward, error) {
@@ -327,13 +399,9 @@ func (c *Client) PortForward(ctx context.Context, req *PortForwardRequest) (*Por
 
 		var name string
 		for _, p := range podList.Items {
-			var ready bool
-			for _, c := range p.Status.ContainerStatuses {
-				if c.Ready {
-					ready = true
-					break
-				}
-			}
+			// Pods being deleted are skipped as they are about to go away,
+			// for example during a rollout of the Platform.
+			ready := IsPodReady(&p) && p.DeletionTimestamp == nil
 			log.Verbose("pod: %s, phase: %s, ready: %t", p.Name, p.Status.Phase, ready)
 			if !ready {
 				continue
@@ -344,7 +412,7 @@ func (c *Client) PortForward(ctx context.Context, req *PortForwardRequest) (*Por
 		if name == "" {
 			return nil, fmt.Errorf("%w: no available httpsrv pod", ErrComponentNotReady)
 		}
-		req.HTTPSrvPod = podList.Items[0].Name
+		req.HTTPSrvPod = name
 	}
 	if req.HTTPSrvPort == 0 {
 		pod := &corev1.Pod{}
@@ -382,8 +450,10 @@ func (c *Client) PortForward(ctx context.Context, req *PortForwardRequest) (*Por
 
 	pf := &PortForward{
 		LocalPort: req.LocalPort,
+		Pod:       req.HTTPSrvPod,
 		stopCh:    make(chan struct{}, 1),
 		readyCh:   make(chan struct{}),
+		doneCh:    make(chan struct{}),
 	}
 
 	scheme := "https"
@@ -421,28 +491,65 @@ func (c *Client) PortForward(ctx context.Context, req *PortForwardRequest) (*Por
 	pf.pfer = pfer
 
 	go func() {
-		if err := pfer.ForwardPorts(); err != nil {
-			log.Fatal("Error with port forward: %v", err)
-		}
+		defer close(pf.doneCh)
+		pf.err = pfer.ForwardPorts()
 	}()
 
 	// Wait for port forward to be ready.
-	<-pf.readyCh
+	select {
+	case <-pf.readyCh:
+	case <-pf.doneCh:
+		return nil, fmt.Errorf("error with port forward to pod '%s': %w", req.HTTPSrvPod, pf.err)
+	case <-ctx.Done():
+		pf.Stop()
+		return nil, ctx.Err()
+	}
 	log.Verbose("Port forward ready; pod: '%s', podPort: '%d', localPort: '%d'.",
 		req.HTTPSrvPod, req.HTTPSrvPort, req.LocalPort)
 
 	return pf, nil
 }
 
+// Stop stops the port forward. It is safe to call more than once.
 func (pf *PortForward) Stop() {
-	pf.pfer.Close()
-	close(pf.stopCh)
+	pf.stopOnce.Do(func() {
+		select {
+		case <-pf.doneCh:
+			// Listeners were already closed when the port forward ended.
+		default:
+			pf.pfer.Close()
+		}
+		close(pf.stopCh)
+	})
 }
 
 func (pf *PortForward) Ready() <-chan struct{} {
 	return pf.readyCh
 }
 
+// Done is closed once the port forward ends, either because it was stopped or
+// the connection to the pod was lost. Err returns the cause.
 func (pf *PortForward) Done() <-chan struct{} {
-	return pf.stopCh
+	return pf.doneCh
+}
+
+// Err returns the error that ended the port forward, nil if it was stopped or
+// is still running.
+func (pf *PortForward) Err() error {
+	select {
+	case <-pf.doneCh:
+		return pf.err
+	default:
+		return nil
+	}
+}
+
+// StreamLogs returns a stream of the logs of the pod's container.
+func (c *Client) StreamLogs(ctx context.Context, pod *corev1.Pod, opts *corev1.PodLogOptions) (io.ReadCloser, error) {
+	cs, err := clientset.NewForConfig(c.RestConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create Kubernetes clientset: %w", err)
+	}
+
+	return cs.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, opts).Stream(ctx)
 }
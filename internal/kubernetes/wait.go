@@ -0,0 +1,468 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xigxog/fox/internal/errs"
+	"github.com/xigxog/fox/internal/log"
+	"github.com/xigxog/kubefox/api"
+	common "github.com/xigxog/kubefox/api/kubernetes"
+	"github.com/xigxog/kubefox/api/kubernetes/v1alpha1"
+	"github.com/xigxog/kubefox/k8s"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// How often pod states are re-evaluated when no events occur.
+	recheckInterval = 5 * time.Second
+	// How long a container without a readiness probe can take to be ready.
+	defaultReadinessGrace = 30 * time.Second
+)
+
+// podState describes why a pod is not ready.
+type podState struct {
+	msg string
+	// problem is true if the pod is not progressing towards ready.
+	problem bool
+	// failed is set to the type of error if the pod will not become ready
+	// without intervention.
+	failed errs.Type
+}
+
+// WaitPodReady waits for the pods of the component to be ready. The pods are
+// watched and their progress is reported as it happens. An error is returned
+// as soon as a pod fails in a way that will not resolve itself, such as
+// ImagePullBackOff or CrashLoopBackOff.
+func (c *Client) WaitPodReady(ctx context.Context, p *v1alpha1.Platform, comp, hash string) error {
+	log.Verbose("Waiting for component '%s' with hash '%s' to be ready...", comp, hash)
+
+	hasLabels := client.MatchingLabels{
+		api.LabelK8sComponent: comp,
+		api.LabelK8sPlatform:  p.Name,
+	}
+	if hash != "" {
+		hasLabels[api.LabelK8sComponentHash] = hash
+	}
+
+	reported := map[string]string{}
+	status := "no pods found"
+	err := c.watchObjects(ctx, &corev1.PodList{}, func(objs []client.Object) (bool, error) {
+		ready, found := true, false
+		for _, obj := range objs {
+			pod := obj.(*corev1.Pod)
+			if pod.DeletionTimestamp != nil {
+				continue
+			}
+			found = true
+			if IsPodReady(pod) {
+				continue
+			}
+			ready = false
+
+			s := getPodState(pod)
+			status = fmt.Sprintf("pod '%s' %s", pod.Name, s.msg)
+			if s.failed != "" {
+				return false, errs.New(s.failed, "component '%s' %s", comp, status)
+			}
+			if reported[pod.Name] != s.msg {
+				reported[pod.Name] = s.msg
+				if s.problem {
+					log.Warn("Component '%s' %s.", comp, status)
+				} else {
+					log.Info("Component '%s' %s.", comp, status)
+				}
+			}
+		}
+		if !found {
+			status = "no pods found"
+		}
+
+		return found && ready, nil
+	}, client.InNamespace(p.Namespace), hasLabels)
+
+	if err != nil && ctx.Err() != nil {
+		return errs.Wrap(errs.TypeTimeout, ctx.Err(), "component '%s' not ready, %s", comp, status)
+	}
+	if err != nil {
+		return err
+	}
+	log.Verbose("Component '%s' with hash '%s' is ready.", comp, hash)
+
+	return nil
+}
+
+// WaitAppDeploymentReady waits for the AppDeployment to be available and its
+// component Deployments to finish progressing. If a component Deployment fails,
+// or the wait times out, the problems reported by the KubeFox Operator are
+// logged and an error is returned.
+func (c *Client) WaitAppDeploymentReady(ctx context.Context, appDep *v1alpha1.AppDeployment) error {
+	log.Info("Waiting for AppDeployment '%s' to be available...", appDep.Name)
+
+	var (
+		problems api.Problems
+		reported string
+		status   = "status not yet updated"
+	)
+	err := c.watchObjects(ctx, &v1alpha1.AppDeploymentList{}, func(objs []client.Object) (bool, error) {
+		if len(objs) == 0 {
+			return false, errs.New(errs.TypeNotFound, "AppDeployment '%s' not found", appDep.Name)
+		}
+		a := objs[0].(*v1alpha1.AppDeployment)
+		problems = a.Status.Problems
+
+		available := k8s.Condition(a.Status.Conditions, api.ConditionTypeAvailable)
+		progressing := k8s.Condition(a.Status.Conditions, api.ConditionTypeProgressing)
+		if available.ObservedGeneration < a.Generation || progressing.ObservedGeneration < a.Generation {
+			return false, nil
+		}
+		status = conditionMessage(available)
+
+		switch {
+		case available.Status == metav1.ConditionTrue && progressing.Status == metav1.ConditionFalse:
+			return true, nil
+		case progressing.Reason == api.ConditionReasonComponentDeploymentFailed:
+			logProblems(problems)
+			return false, errs.New(errs.TypeNotReady, "AppDeployment '%s' failed, %s", a.Name, conditionMessage(progressing))
+		}
+		if msg := conditionMessage(progressing); msg != reported {
+			reported = msg
+			log.Info("AppDeployment '%s' %s", a.Name, msg)
+		}
+
+		return false, nil
+	}, client.InNamespace(appDep.Namespace), client.MatchingFields{"metadata.name": appDep.Name})
+
+	if err != nil && ctx.Err() != nil {
+		logProblems(problems)
+		return errs.Wrap(errs.TypeTimeout, ctx.Err(), "AppDeployment '%s' not available, %s", appDep.Name, status)
+	}
+	if err != nil {
+		return err
+	}
+	log.Verbose("AppDeployment '%s' is available.", appDep.Name)
+
+	return nil
+}
+
+// WaitVirtualEnvReady waits for the Release of the VirtualEnvironment to be
+// activated and available, meaning its routes are active. app and appDep are
+// the app and AppDeployment that must be part of the active Release, ve must be
+// the VirtualEnvironment as returned by the update of its Release. If the
+// Release fails to activate before its deadline, or the wait times out, the
+// problems reported by the KubeFox Operator are logged and an error is
+// returned.
+func (c *Client) WaitVirtualEnvReady(ctx context.Context, ve *v1alpha1.VirtualEnvironment, app, appDep string) error {
+	log.Info("Waiting for Release of VirtualEnvironment '%s' to be activated...", ve.Name)
+
+	var (
+		problems  api.Problems
+		pendingId string
+		reported  string
+		status    = "status not yet updated"
+	)
+	err := c.watchObjects(ctx, &v1alpha1.VirtualEnvironmentList{}, func(objs []client.Object) (bool, error) {
+		if len(objs) == 0 {
+			return false, errs.New(errs.TypeNotFound, "VirtualEnvironment '%s' not found", ve.Name)
+		}
+		v := objs[0].(*v1alpha1.VirtualEnvironment)
+
+		pending := k8s.Condition(v.Status.Conditions, api.ConditionTypeReleasePending)
+		available := k8s.Condition(v.Status.Conditions, api.ConditionTypeActiveReleaseAvailable)
+		if pending.ObservedGeneration < ve.Generation || available.ObservedGeneration < ve.Generation {
+			return false, nil
+		}
+
+		active, pendingRel := v.Status.ActiveRelease, v.Status.PendingRelease
+		if pendingRel != nil && pendingRel.Apps[app].AppDeployment == appDep {
+			pendingId = pendingRel.Id
+			problems = releaseProblems(pendingRel.Problems)
+		}
+		// A Release that fails to activate before its deadline is archived to
+		// history.
+		for _, rel := range v.Status.ReleaseHistory {
+			if pendingId != "" && rel.Id == pendingId && rel.ArchiveReason == api.ArchiveReasonPendingDeadlineExceeded {
+				problems = releaseProblems(rel.Problems)
+				logProblems(problems)
+				return false, errs.New(errs.TypeNotReady, "Release of VirtualEnvironment '%s' failed, %s", v.Name, conditionMessage(pending))
+			}
+		}
+
+		switch {
+		case active != nil && active.Apps[app].AppDeployment == appDep && available.Status == metav1.ConditionTrue:
+			return true, nil
+		case pendingRel != nil:
+			status = conditionMessage(pending)
+		case available.Status != metav1.ConditionTrue:
+			status = conditionMessage(available)
+			if active != nil {
+				problems = releaseProblems(active.Problems)
+			}
+		default:
+			status = fmt.Sprintf("AppDeployment '%s' is not part of the active Release", appDep)
+		}
+		if status != reported {
+			reported = status
+			log.Info("VirtualEnvironment '%s' %s", v.Name, status)
+		}
+
+		return false, nil
+	}, client.InNamespace(ve.Namespace), client.MatchingFields{"metadata.name": ve.Name})
+
+	if err != nil && ctx.Err() != nil {
+		logProblems(problems)
+		return errs.Wrap(errs.TypeTimeout, ctx.Err(), "Release of VirtualEnvironment '%s' not activated, %s", ve.Name, status)
+	}
+	if err != nil {
+		return err
+	}
+	log.Verbose("Release of VirtualEnvironment '%s' is active.", ve.Name)
+
+	return nil
+}
+
+// getPodState returns the reason the pod is not ready.
+func getPodState(pod *corev1.Pod) *podState {
+	if pod.Status.Phase == corev1.PodFailed {
+		return &podState{
+			msg:    fmt.Sprintf("failed: %s", podMessage(pod.Status.Reason, pod.Status.Message)),
+			failed: errs.TypeNotReady,
+		}
+	}
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodScheduled && cond.Status == corev1.ConditionFalse {
+			return &podState{
+				msg:     fmt.Sprintf("cannot be scheduled: %s", podMessage(cond.Reason, cond.Message)),
+				problem: true,
+			}
+		}
+	}
+
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...),
+		pod.Status.ContainerStatuses...)
+	for _, cs := range statuses {
+		switch {
+		case cs.State.Waiting != nil:
+			w := cs.State.Waiting
+			s := &podState{
+				msg: fmt.Sprintf("container '%s' is waiting: %s", cs.Name, podMessage(w.Reason, w.Message)),
+			}
+			switch w.Reason {
+			case "ImagePullBackOff", "ErrImageNeverPull", "InvalidImageName":
+				s.failed = errs.TypeMissingImage
+			case "CrashLoopBackOff", "CreateContainerConfigError", "CreateContainerError", "RunContainerError":
+				s.failed = errs.TypeNotReady
+			case "ErrImagePull":
+				s.problem = true
+			}
+			return s
+
+		case cs.State.Terminated != nil && !cs.Ready:
+			t := cs.State.Terminated
+			return &podState{
+				msg:     fmt.Sprintf("container '%s' terminated with exit code %d: %s", cs.Name, t.ExitCode, podMessage(t.Reason, t.Message)),
+				problem: t.ExitCode != 0,
+			}
+
+		case cs.State.Running != nil && !cs.Ready:
+			// Readiness probes take time to pass after a container starts,
+			// only once they have had time to pass is it a problem.
+			if time.Since(cs.State.Running.StartedAt.Time) < readinessGrace(pod, cs.Name) {
+				return &podState{
+					msg: fmt.Sprintf("container '%s' is running, waiting for it to be ready", cs.Name),
+				}
+			}
+			return &podState{
+				msg:     fmt.Sprintf("container '%s' is running but not ready, its readiness probe is failing", cs.Name),
+				problem: true,
+			}
+		}
+	}
+
+	return &podState{msg: fmt.Sprintf("is %s", pod.Status.Phase)}
+}
+
+// readinessGrace returns how long the readiness probe of the container can
+// take to pass, using the probe's initial delay and the time it takes to fail
+// the probe's failure threshold.
+func readinessGrace(pod *corev1.Pod, container string) time.Duration {
+	for _, c := range pod.Spec.Containers {
+		if c.Name != container || c.ReadinessProbe == nil {
+			continue
+		}
+		p := c.ReadinessProbe
+		// Kubernetes defaults unset periods and thresholds.
+		period, failures := int32(10), int32(3)
+		if p.PeriodSeconds > 0 {
+			period = p.PeriodSeconds
+		}
+		if p.FailureThreshold > 0 {
+			failures = p.FailureThreshold
+		}
+		return time.Duration(p.InitialDelaySeconds+period*failures) * time.Second
+	}
+
+	return defaultReadinessGrace
+}
+
+func podMessage(reason, msg string) string {
+	switch {
+	case reason == "":
+		return msg
+	case msg == "":
+		return reason
+	default:
+		return reason + ", " + msg
+	}
+}
+
+func conditionMessage(cond *metav1.Condition) string {
+	if cond.Message == "" {
+		return fmt.Sprintf("%s is %s", cond.Type, cond.Status)
+	}
+	return fmt.Sprintf("%s: %s", cond.Reason, cond.Message)
+}
+
+func logProblems(problems api.Problems) {
+	for _, p := range problems {
+		causes := make([]string, 0, len(p.Causes))
+		for _, c := range p.Causes {
+			cause := fmt.Sprintf("%s '%s'", c.Kind, c.Name)
+			if c.Path != "" {
+				cause += " " + c.Path
+			}
+			causes = append(causes, cause)
+		}
+		if len(causes) > 0 {
+			log.Error("Problem %s: %s (%s)", p.Type, p.Message, strings.Join(causes, ", "))
+		} else {
+			log.Error("Problem %s: %s", p.Type, p.Message)
+		}
+	}
+}
+
+func releaseProblems(problems []common.Problem) api.Problems {
+	probs := make(api.Problems, 0, len(problems))
+	for _, p := range problems {
+		probs = append(probs, p.Problem)
+	}
+
+	return probs
+}
+
+// watchObjects lists the objects matching opts and watches them for changes.
+// check is called with the current objects, sorted by name, after they are
+// listed and after each change until it returns true or an error, or ctx is
+// done. If the watch expires the objects are listed again.
+func (c *Client) watchObjects(ctx context.Context, list client.ObjectList,
+	check func([]client.Object) (bool, error), opts ...client.ListOption) error {
+
+	for {
+		if err := c.List(ctx, list, opts...); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("unable to list resources: %w", err)
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			return err
+		}
+		objs := map[types.NamespacedName]client.Object{}
+		for _, item := range items {
+			if obj, ok := item.(client.Object); ok {
+				objs[client.ObjectKeyFromObject(obj)] = obj
+			}
+		}
+		if done, err := check(sortObjects(objs)); done || err != nil {
+			return err
+		}
+
+		w, err := c.watcher.Watch(ctx, list, append(opts, &client.ListOptions{
+			Raw: &metav1.ListOptions{ResourceVersion: list.GetResourceVersion()},
+		})...)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("unable to watch resources: %w", err)
+		}
+		done, err := watchEvents(ctx, w, objs, check)
+		w.Stop()
+		if done || err != nil {
+			return err
+		}
+		log.Verbose("Watch expired, listing resources again.")
+	}
+}
+
+// watchEvents applies events from w to objs, calling check after each and
+// periodically so time based states are re-evaluated. False and no error is
+// returned if the watch ends before check returns true.
+func watchEvents(ctx context.Context, w watch.Interface, objs map[types.NamespacedName]client.Object,
+	check func([]client.Object) (bool, error)) (bool, error) {
+
+	ticker := time.NewTicker(recheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+
+		case <-ticker.C:
+			if done, err := check(sortObjects(objs)); done || err != nil {
+				return done, err
+			}
+
+		case ev, ok := <-w.ResultChan():
+			if !ok {
+				return false, nil
+			}
+			obj, isObj := ev.Object.(client.Object)
+			switch {
+			case ev.Type == watch.Error || !isObj:
+				// Usually caused by the resource version expiring.
+				return false, nil
+			case ev.Type == watch.Bookmark:
+				continue
+			case ev.Type == watch.Deleted:
+				delete(objs, client.ObjectKeyFromObject(obj))
+			default:
+				objs[client.ObjectKeyFromObject(obj)] = obj
+			}
+
+			if done, err := check(sortObjects(objs)); done || err != nil {
+				return done, err
+			}
+		}
+	}
+}
+
+func sortObjects(objs map[types.NamespacedName]client.Object) []client.Object {
+	sorted := make([]client.Object, 0, len(objs))
+	for _, obj := range objs {
+		sorted = append(sorted, obj)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].GetName() < sorted[j].GetName()
+	})
+
+	return sorted
+}
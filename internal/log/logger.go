@@ -9,10 +9,12 @@
 package log
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 
+	"github.com/xigxog/fox/internal/errs"
 	"github.com/xigxog/kubefox/logkf"
 	"sigs.k8s.io/yaml"
 )
@@ -22,8 +24,21 @@ var (
 	OutputFormat  string        = "json"
 	EnableInfo    bool
 	EnableVerbose bool
+
+	// EnableErrorObject causes Fatal to also write the error to stdout as a
+	// structured object, allowing it to be parsed by scripts.
+	EnableErrorObject bool
 )
 
+// Prefixed writes log messages with a prefix, allowing the output of
+// concurrent operations to be told apart. A nil Prefixed writes messages
+// without a prefix.
+type Prefixed struct {
+	prefix string
+}
+
+type ctxKey struct{}
+
 func Logger() *logkf.Logger {
 	return log
 }
@@ -36,6 +51,12 @@ func Marshal(o any) {
 	fmt.Print(marshal(o))
 }
 
+// Eprint writes s to stderr. It is used for output that is always shown but is
+// not the result of the command.
+func Eprint(s string) {
+	fmt.Fprint(os.Stderr, s)
+}
+
 func InfoNewline() {
 	if !EnableInfo {
 		return
@@ -85,10 +106,73 @@ func Error(format string, v ...any) {
 	log.Errorf(format, v...)
 }
 
+// Fatal logs the message and exits. If one of v is an error the exit code is
+// determined by its errs.Type.
 func Fatal(format string, v ...any) {
-	format = "😖 " + format
-	log.Errorf(format, v...)
-	os.Exit(1)
+	var err error
+	for _, a := range v {
+		if e, ok := a.(error); ok {
+			err = e
+			break
+		}
+	}
+	msg := fmt.Sprintf(format, v...)
+
+	log.Errorf("😖 %s", msg)
+	if EnableErrorObject {
+		Marshal(map[string]any{"error": errs.ToObject(err, msg)})
+	}
+	os.Exit(errs.ExitCode(err))
+}
+
+func WithPrefix(prefix string) *Prefixed {
+	return &Prefixed{prefix: prefix}
+}
+
+// NewContext returns a copy of ctx carrying l. It can be retrieved using
+// FromContext.
+func NewContext(ctx context.Context, l *Prefixed) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// FromContext returns the Prefixed logger carried by ctx. If ctx does not
+// carry a logger nil is returned, which writes messages without a prefix.
+func FromContext(ctx context.Context) *Prefixed {
+	l, _ := ctx.Value(ctxKey{}).(*Prefixed)
+	return l
+}
+
+func (l *Prefixed) Info(format string, v ...any) {
+	Info("%s"+format, l.args(v)...)
+}
+
+func (l *Prefixed) InfoMarshal(o any, format string, v ...any) {
+	InfoMarshal(o, "%s"+format, l.args(v)...)
+}
+
+func (l *Prefixed) Verbose(format string, v ...any) {
+	Verbose("%s"+format, l.args(v)...)
+}
+
+func (l *Prefixed) VerboseMarshal(o any, format string, v ...any) {
+	VerboseMarshal(o, "%s"+format, l.args(v)...)
+}
+
+func (l *Prefixed) Warn(format string, v ...any) {
+	Warn("%s"+format, l.args(v)...)
+}
+
+func (l *Prefixed) Error(format string, v ...any) {
+	Error("%s"+format, l.args(v)...)
+}
+
+func (l *Prefixed) args(v []any) []any {
+	var prefix string
+	if l != nil {
+		prefix = l.prefix
+	}
+
+	return append([]any{prefix}, v...)
 }
 
 func marshal(o any) string {
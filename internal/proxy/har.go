@@ -0,0 +1,485 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package proxy
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/xigxog/fox/internal/log"
+	"github.com/xigxog/kubefox/build"
+)
+
+// HAR is an HTTP Archive in HAR 1.2 format. Only the fields used by 🦊 Fox are
+// included, see http://www.softwareishard.com/blog/har-12-spec/.
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+}
+
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type HAREntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type HARRequest struct {
+	Method      string       `json:"method"`
+	URL         string       `json:"url"`
+	HTTPVersion string       `json:"httpVersion"`
+	Cookies     []HARCookie  `json:"cookies"`
+	Headers     []HARNameVal `json:"headers"`
+	QueryString []HARNameVal `json:"queryString"`
+	PostData    *HARPostData `json:"postData,omitempty"`
+	HeadersSize int          `json:"headersSize"`
+	BodySize    int64        `json:"bodySize"`
+	Comment     string       `json:"comment,omitempty"`
+}
+
+type HARResponse struct {
+	Status      int          `json:"status"`
+	StatusText  string       `json:"statusText"`
+	HTTPVersion string       `json:"httpVersion"`
+	Cookies     []HARCookie  `json:"cookies"`
+	Headers     []HARNameVal `json:"headers"`
+	Content     HARContent   `json:"content"`
+	RedirectURL string       `json:"redirectURL"`
+	HeadersSize int          `json:"headersSize"`
+	BodySize    int64        `json:"bodySize"`
+	Comment     string       `json:"comment,omitempty"`
+}
+
+type HARNameVal struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARCookie struct {
+	Name     string     `json:"name"`
+	Value    string     `json:"value"`
+	Path     string     `json:"path,omitempty"`
+	Domain   string     `json:"domain,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	HTTPOnly bool       `json:"httpOnly,omitempty"`
+	Secure   bool       `json:"secure,omitempty"`
+}
+
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type HARContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// HARTimings are in milliseconds, -1 is used for timings that do not apply.
+type HARTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// Values of headers and cookies that carry credentials are replaced with this
+// unless secrets are recorded.
+const redacted = "REDACTED"
+
+// Headers that carry credentials.
+var secretHeaders = []string{
+	"Authorization",
+	"Cookie",
+	"Proxy-Authorization",
+	"Set-Cookie",
+}
+
+// recorder records requests made through the proxy and writes them to a HAR
+// file. Bodies larger than the limit are truncated. Entries are appended to
+// the file as they are flushed so only unflushed entries are kept in memory.
+type recorder struct {
+	path      string
+	bodyLimit int
+	secrets   bool
+
+	file *os.File
+	// end is the offset in the file where the next entry is written, trailer
+	// follows it.
+	end     int64
+	trailer []byte
+	count   int
+	pending []HAREntry
+	mutex   sync.Mutex
+}
+
+// exchange is a single request/response pair captured by the proxy.
+type exchange struct {
+	req     *http.Request
+	reqBody *capture
+	start   time.Time
+	// respStart is when the response headers were received from the pod.
+	respStart time.Time
+	end       time.Time
+
+	status  int
+	header  http.Header
+	body    *capture
+	comment string
+}
+
+// capture is a writer storing up to limit bytes while counting all bytes
+// written.
+type capture struct {
+	buf   []byte
+	size  int64
+	limit int
+}
+
+// newRecorder creates the HAR file with no entries. If secrets is false the
+// values of headers and cookies that carry credentials are redacted.
+func newRecorder(path string, bodyLimit int, secrets bool) (*recorder, error) {
+	har := HAR{
+		Log: HARLog{
+			Version: "1.2",
+			Creator: HARCreator{Name: "fox", Version: build.Info.Version},
+			Entries: []HAREntry{},
+		},
+	}
+	b, err := json.MarshalIndent(har, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling HAR: %w", err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating HAR file: %w", err)
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error writing HAR file: %w", err)
+	}
+
+	// Entries are written between the brackets of the empty entries array.
+	i := bytes.LastIndex(b, []byte("[]")) + 1
+
+	return &recorder{
+		path:      path,
+		bodyLimit: bodyLimit,
+		secrets:   secrets,
+		file:      f,
+		end:       int64(i),
+		trailer:   b[i:],
+	}, nil
+}
+
+// start begins recording the exchange. The request body is captured as it is
+// read and the response is captured as it is written to the returned
+// ResponseWriter.
+func (r *recorder) start(req *http.Request, rw http.ResponseWriter) (*exchange, http.ResponseWriter) {
+	ex := &exchange{
+		req:    req,
+		start:  time.Now(),
+		header: rw.Header(),
+		body:   &capture{limit: r.bodyLimit},
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		ex.reqBody = &capture{limit: r.bodyLimit}
+		req.Body = &teeBody{Reader: io.TeeReader(req.Body, ex.reqBody), Closer: req.Body}
+	}
+
+	return ex, &recordingWriter{ResponseWriter: rw, ex: ex}
+}
+
+type teeBody struct {
+	io.Reader
+	io.Closer
+}
+
+// recordingWriter captures the status and body of the response written to it.
+type recordingWriter struct {
+	http.ResponseWriter
+	ex *exchange
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if w.ex.status == 0 {
+		w.ex.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if w.ex.status == 0 {
+		w.ex.status = http.StatusOK
+	}
+	w.ex.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (c *capture) Write(p []byte) (int, error) {
+	c.size += int64(len(p))
+	if room := c.limit - len(c.buf); room > 0 {
+		c.buf = append(c.buf, p[:min(room, len(p))]...)
+	}
+
+	return len(p), nil
+}
+
+func (c *capture) truncated() bool {
+	return c.size > int64(len(c.buf))
+}
+
+// record adds the exchange to the HAR.
+func (r *recorder) record(ex *exchange) {
+	req := ex.req
+	ex.end = time.Now()
+	if ex.respStart.IsZero() {
+		ex.respStart = ex.end
+	}
+
+	entry := HAREntry{
+		StartedDateTime: ex.start,
+		Time:            millis(ex.end.Sub(ex.start)),
+		Request: HARRequest{
+			Method:      req.Method,
+			URL:         fmt.Sprintf("http://%s%s", req.Host, req.URL.RequestURI()),
+			HTTPVersion: req.Proto,
+			Cookies:     r.harCookies(req.Cookies()),
+			Headers:     r.harHeaders(req.Header),
+			QueryString: []HARNameVal{},
+			HeadersSize: -1,
+			BodySize:    0,
+		},
+		Response: HARResponse{
+			Status:      ex.status,
+			StatusText:  http.StatusText(ex.status),
+			HTTPVersion: req.Proto,
+			Cookies:     r.harCookies((&http.Response{Header: ex.header}).Cookies()),
+			Headers:     r.harHeaders(ex.header),
+			Content: HARContent{
+				Size:     ex.body.size,
+				MimeType: ex.header.Get("Content-Type"),
+			},
+			RedirectURL: ex.header.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    ex.body.size,
+			Comment:     ex.comment,
+		},
+		Timings: HARTimings{
+			Blocked: -1,
+			DNS:     -1,
+			Connect: -1,
+			Send:    0,
+			Wait:    millis(ex.respStart.Sub(ex.start)),
+			Receive: millis(ex.end.Sub(ex.respStart)),
+		},
+	}
+	for k, vals := range req.URL.Query() {
+		for _, v := range vals {
+			entry.Request.QueryString = append(entry.Request.QueryString, HARNameVal{Name: k, Value: v})
+		}
+	}
+	sort.Slice(entry.Request.QueryString, func(i, j int) bool {
+		return entry.Request.QueryString[i].Name < entry.Request.QueryString[j].Name
+	})
+
+	if ex.reqBody != nil && ex.reqBody.size > 0 {
+		text, enc := bodyText(ex.reqBody.buf)
+		entry.Request.BodySize = ex.reqBody.size
+		entry.Request.PostData = &HARPostData{
+			MimeType: req.Header.Get("Content-Type"),
+			Text:     text,
+			Encoding: enc,
+			Comment:  truncatedComment(ex.reqBody),
+		}
+	}
+	if ex.body.size > 0 {
+		entry.Response.Content.Text, entry.Response.Content.Encoding = bodyText(ex.body.buf)
+		entry.Response.Content.Comment = truncatedComment(ex.body)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.pending = append(r.pending, entry)
+}
+
+// flush writes the HAR to the recorder's file if new entries were recorded.
+func (r *recorder) flush() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if len(r.pending) == 0 {
+		return nil
+	}
+
+	buf := &bytes.Buffer{}
+	for i, entry := range r.pending {
+		b, err := json.MarshalIndent(entry, "      ", "  ")
+		if err != nil {
+			return fmt.Errorf("error marshaling HAR entry: %w", err)
+		}
+		if r.count+i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("\n      ")
+		buf.Write(b)
+	}
+	entries := buf.Len()
+	// The trailer is rewritten after the new entries so the file is always
+	// complete.
+	buf.WriteString("\n    ")
+	buf.Write(r.trailer)
+	if _, err := r.file.WriteAt(buf.Bytes(), r.end); err != nil {
+		return fmt.Errorf("error writing HAR file: %w", err)
+	}
+	// The closing whitespace of the entries array is overwritten by the next
+	// entries.
+	r.end += int64(entries)
+	r.count += len(r.pending)
+	r.pending = nil
+	log.Verbose("Wrote %d requests to HAR file '%s'.", r.count, r.path)
+
+	return nil
+}
+
+// close flushes pending entries and closes the HAR file.
+func (r *recorder) close() error {
+	err := r.flush()
+	if cerr := r.file.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("error closing HAR file: %w", cerr)
+	}
+
+	return err
+}
+
+// flushEvery writes the HAR file periodically until done is closed, so the
+// recording survives the proxy being killed.
+func (r *recorder) flushEvery(d time.Duration, done <-chan struct{}) {
+	t := time.NewTicker(d)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			if err := r.flush(); err != nil {
+				log.Error("%v", err)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// bodyText returns the body as text, base64 encoding it if it is not valid
+// UTF-8.
+func bodyText(b []byte) (string, string) {
+	if utf8.Valid(b) {
+		return string(b), ""
+	}
+	return base64.StdEncoding.EncodeToString(b), "base64"
+}
+
+func truncatedComment(c *capture) string {
+	if !c.truncated() {
+		return ""
+	}
+	return fmt.Sprintf("body truncated to %d of %d bytes", len(c.buf), c.size)
+}
+
+func (r *recorder) harHeaders(h http.Header) []HARNameVal {
+	l := []HARNameVal{}
+	for _, k := range sortedHeaderKeys(h) {
+		secret := !r.secrets && isSecretHeader(k)
+		for _, v := range h[k] {
+			if secret {
+				v = redacted
+			}
+			l = append(l, HARNameVal{Name: k, Value: v})
+		}
+	}
+
+	return l
+}
+
+func sortedHeaderKeys(h http.Header) []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func isSecretHeader(name string) bool {
+	return slices.ContainsFunc(secretHeaders, func(h string) bool {
+		return strings.EqualFold(h, name)
+	})
+}
+
+func (r *recorder) harCookies(cookies []*http.Cookie) []HARCookie {
+	l := []HARCookie{}
+	for _, c := range cookies {
+		v := c.Value
+		if !r.secrets {
+			v = redacted
+		}
+		hc := HARCookie{
+			Name:     c.Name,
+			Value:    v,
+			Path:     c.Path,
+			Domain:   c.Domain,
+			HTTPOnly: c.HttpOnly,
+			Secure:   c.Secure,
+		}
+		if !c.Expires.IsZero() {
+			exp := c.Expires
+			hc.Expires = &exp
+		}
+		l = append(l, hc)
+	}
+
+	return l
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,139 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/xigxog/fox/internal/log"
+	"github.com/xigxog/kubefox/api"
+	"github.com/xigxog/kubefox/core"
+)
+
+const (
+	// Only the first bytes of response bodies are compared, the sizes of the
+	// bodies are always compared.
+	mirrorBodyLimit = 1024 * 1024
+	// Requests with larger bodies are not mirrored as the body must be kept in
+	// memory to be sent twice.
+	maxMirrorRequestBody = 1024 * 1024
+	// Requests are not mirrored while this many mirrored requests are in
+	// flight so a slow candidate does not exhaust the proxy.
+	maxMirrorsInFlight = 64
+)
+
+// mirror is a copy of a proxied request that is sent to the candidate
+// AppDeployment once the response to the original request is complete.
+type mirror struct {
+	req  *http.Request
+	body []byte
+
+	// status and body of the response to the original request.
+	status   int
+	respBody *capture
+}
+
+// newMirror copies the request for mirroring, the body of the request is read
+// and replaced so it can be sent twice. If the body is larger than
+// maxMirrorRequestBody nil is returned and the request is sent unchanged
+// without being mirrored.
+func (srv *ProxyServer) newMirror(req *http.Request) (*mirror, error) {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.ContentLength > maxMirrorRequestBody {
+			logTooLarge(req)
+			return nil, nil
+		}
+		b, err := io.ReadAll(io.LimitReader(req.Body, maxMirrorRequestBody+1))
+		if err != nil {
+			req.Body.Close()
+			return nil, fmt.Errorf("error reading request body: %w", err)
+		}
+		if len(b) > maxMirrorRequestBody {
+			// Stream the rest of the body after the bytes already read.
+			req.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(b), req.Body), req.Body}
+			logTooLarge(req)
+			return nil, nil
+		}
+		req.Body.Close()
+		body = b
+		req.Body = io.NopCloser(bytes.NewReader(b))
+	}
+
+	m := &mirror{
+		req:      req.Clone(srv.ctx),
+		body:     body,
+		respBody: &capture{limit: mirrorBodyLimit},
+	}
+	core.DelParamOrHeader(m.req, api.HeaderAppDeployment, api.HeaderAppDeploymentAbbrv)
+	m.req.Header.Set(api.HeaderAppDeployment, srv.cfg.Flags.MirrorDeployment)
+
+	return m, nil
+}
+
+func logTooLarge(req *http.Request) {
+	log.Warn("Request body larger than %d bytes, not mirroring %s %s.", maxMirrorRequestBody, req.Method, req.URL.Path)
+}
+
+// sendMirror sends the mirrored request to the candidate AppDeployment in the
+// background and logs any divergence from the response to the original
+// request.
+func (srv *ProxyServer) sendMirror(m *mirror) {
+	select {
+	case srv.mirrorSem <- struct{}{}:
+	default:
+		log.Warn("Too many mirrored requests in flight, not mirroring %s %s.", m.req.Method, m.req.URL.Path)
+		return
+	}
+
+	go func() {
+		defer func() { <-srv.mirrorSem }()
+
+		m.req.Body = http.NoBody
+		if m.body != nil {
+			m.req.Body = io.NopCloser(bytes.NewReader(m.body))
+		}
+
+		resp, err := srv.send(m.req)
+		if err != nil {
+			log.Warn("Error mirroring %s %s to AppDeployment '%s': %v",
+				m.req.Method, m.req.URL.Path, srv.cfg.Flags.MirrorDeployment, err)
+			return
+		}
+		defer resp.Body.Close()
+
+		body := &capture{limit: mirrorBodyLimit}
+		if _, err := io.Copy(body, resp.Body); err != nil {
+			log.Warn("Error reading mirrored response to %s %s: %v", m.req.Method, m.req.URL.Path, err)
+			return
+		}
+
+		var diffs []string
+		if resp.StatusCode != m.status {
+			diffs = append(diffs, fmt.Sprintf("status %d, candidate status %d", m.status, resp.StatusCode))
+		}
+		if off := diffOffset(m.respBody.buf, body.buf, m.respBody.size, body.size); off >= 0 {
+			diffs = append(diffs, fmt.Sprintf("body differs at byte %d, %d bytes, candidate %d bytes",
+				off, m.respBody.size, body.size))
+		}
+		if len(diffs) > 0 {
+			log.Warn("Mirrored response to %s %s from AppDeployment '%s' diverged: %s.",
+				m.req.Method, m.req.URL.RequestURI(), srv.cfg.Flags.MirrorDeployment, strings.Join(diffs, "; "))
+		} else {
+			log.Verbose("Mirrored response to %s %s matched.", m.req.Method, m.req.URL.RequestURI())
+		}
+	}()
+}
@@ -0,0 +1,357 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xigxog/fox/internal/kubernetes"
+	"github.com/xigxog/fox/internal/log"
+	"github.com/xigxog/kubefox/api/kubernetes/v1alpha1"
+)
+
+const (
+	BalanceRoundRobin = "round-robin"
+	BalanceLeastConn  = "least-conn"
+)
+
+var Balancers = []string{BalanceRoundRobin, BalanceLeastConn}
+
+var errUnavailable = errors.New("no httpsrv pod available")
+
+// pool keeps a port forward to every ready httpsrv pod of the Platform. Pods
+// are watched and port forwards are added and removed as pods become ready or
+// go away. Broken port forwards are re-established as long as their pod
+// remains ready.
+type pool struct {
+	k8s      *kubernetes.Client
+	platform *v1alpha1.Platform
+	balance  string
+	timeout  time.Duration
+
+	// pods are the names of the ready httpsrv pods, sorted by name.
+	pods     []string
+	backends map[string]*backend
+	// changed is closed and replaced each time a backend is added.
+	changed chan struct{}
+	next    int
+	mutex   sync.Mutex
+
+	syncCh chan struct{}
+	ctx    context.Context
+}
+
+type backend struct {
+	pf *kubernetes.PortForward
+	// active is the number of requests in flight.
+	active atomic.Int64
+	// broken is set once a connection error occurs, the backend is no longer
+	// used while its port forward is re-established.
+	broken atomic.Bool
+}
+
+func newPool(ctx context.Context, c *kubernetes.Client, p *v1alpha1.Platform, balance string, timeout time.Duration) *pool {
+	if balance == "" {
+		balance = BalanceRoundRobin
+	}
+
+	return &pool{
+		k8s:      c,
+		platform: p,
+		balance:  balance,
+		timeout:  timeout,
+		backends: map[string]*backend{},
+		changed:  make(chan struct{}),
+		syncCh:   make(chan struct{}, 1),
+		ctx:      ctx,
+	}
+}
+
+// start establishes port forwards to the ready httpsrv pods. If wait is true
+// and no pod is ready it waits for one. Once started the pool tracks pods
+// until its context is done.
+func (pl *pool) start(ctx context.Context, wait bool) error {
+	pods, err := pl.k8s.ReadyPods(ctx, pl.platform, "httpsrv")
+	if err != nil {
+		return err
+	}
+	if len(pods) == 0 && wait {
+		log.Warn("No httpsrv pod is available.")
+		log.Info("Waiting for httpsrv pod to become available...")
+
+		if err := pl.k8s.WaitPodReady(ctx, pl.platform, "httpsrv", ""); err != nil {
+			return err
+		}
+		if pods, err = pl.k8s.ReadyPods(ctx, pl.platform, "httpsrv"); err != nil {
+			return err
+		}
+	}
+	if len(pods) == 0 {
+		return fmt.Errorf("%w: no available httpsrv pod", kubernetes.ErrComponentNotReady)
+	}
+
+	pl.pods = pods
+	pl.sync(ctx)
+	if len(pl.backends) == 0 {
+		return fmt.Errorf("%w: unable to port forward to any httpsrv pod", errUnavailable)
+	}
+
+	go pl.watchPods()
+	go pl.maintain()
+
+	return nil
+}
+
+// watchPods updates the ready pods as they change, the watch is restarted if
+// it fails.
+func (pl *pool) watchPods() {
+	backoff := time.Second / 2
+	for {
+		err := pl.k8s.WatchReadyPods(pl.ctx, pl.platform, "httpsrv", func(pods []string) {
+			backoff = time.Second / 2
+			log.Verbose("Ready httpsrv pods: %v", pods)
+
+			pl.mutex.Lock()
+			pl.pods = pods
+			pl.mutex.Unlock()
+			pl.trigger()
+		})
+		if pl.ctx.Err() != nil {
+			return
+		}
+
+		log.Warn("Error watching httpsrv pods, retrying in %s: %v", backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-pl.ctx.Done():
+			return
+		}
+		backoff = min(backoff*2, 10*time.Second)
+	}
+}
+
+// maintain syncs the port forwards with the ready pods each time they change
+// or a port forward breaks. If a port forward cannot be established it is
+// retried with a backoff.
+func (pl *pool) maintain() {
+	backoff := time.Second / 2
+	for {
+		var retry <-chan time.Time
+		if !pl.sync(pl.ctx) {
+			log.Warn("Unable to port forward to all httpsrv pods, retrying in %s.", backoff)
+			retry = time.After(backoff)
+			backoff = min(backoff*2, 10*time.Second)
+		} else {
+			backoff = time.Second / 2
+		}
+
+		select {
+		case <-pl.syncCh:
+		case <-retry:
+		case <-pl.ctx.Done():
+			pl.stopAll()
+			return
+		}
+	}
+}
+
+func (pl *pool) trigger() {
+	select {
+	case pl.syncCh <- struct{}{}:
+	default:
+	}
+}
+
+// sync stops port forwards to pods that are no longer ready and establishes
+// port forwards to ready pods without one. False is returned if any port
+// forward could not be established.
+func (pl *pool) sync(ctx context.Context) bool {
+	pl.mutex.Lock()
+	pods := pl.pods
+	var toAdd []string
+	for _, pod := range pods {
+		if b := pl.backends[pod]; b == nil || isDone(b.pf) {
+			toAdd = append(toAdd, pod)
+		}
+	}
+	for pod, b := range pl.backends {
+		switch {
+		case !slices.Contains(pods, pod):
+			log.Info("Stopping forwarding to httpsrv pod '%s', it is no longer ready.", pod)
+			delete(pl.backends, pod)
+			go pl.drain(b)
+			continue
+		case isDone(b.pf):
+			if err := b.pf.Err(); err != nil {
+				log.Warn("Port forward to httpsrv pod '%s' lost: %v", pod, err)
+			} else {
+				log.Warn("Port forward to httpsrv pod '%s' stopped after a connection error.", pod)
+			}
+		default:
+			continue
+		}
+		delete(pl.backends, pod)
+		b.pf.Stop()
+	}
+	pl.mutex.Unlock()
+
+	ok := true
+	for _, pod := range toAdd {
+		pfCtx, cancel := context.WithTimeout(ctx, pl.timeout)
+		pf, err := pl.k8s.PortForward(pfCtx, &kubernetes.PortForwardRequest{
+			Namespace:  pl.platform.Namespace,
+			Platform:   pl.platform.Name,
+			HTTPSrvPod: pod,
+		})
+		cancel()
+		if err != nil {
+			log.Warn("Unable to port forward to httpsrv pod '%s': %v", pod, err)
+			ok = false
+			continue
+		}
+		pl.add(pod, pf)
+	}
+
+	return ok
+}
+
+func (pl *pool) add(pod string, pf *kubernetes.PortForward) {
+	pl.mutex.Lock()
+	defer pl.mutex.Unlock()
+
+	if !slices.Contains(pl.pods, pod) || pl.ctx.Err() != nil {
+		// Pod went away while connecting.
+		pf.Stop()
+		return
+	}
+	pl.backends[pod] = &backend{pf: pf}
+	close(pl.changed)
+	pl.changed = make(chan struct{})
+	log.Info("Forwarding to httpsrv pod '%s'.", pod)
+
+	go func() {
+		<-pf.Done()
+		pl.trigger()
+	}()
+}
+
+// remove stops the backend's port forward, it is re-established if the pod
+// is still ready.
+func (pl *pool) remove(b *backend) {
+	b.broken.Store(true)
+	b.pf.Stop()
+	pl.trigger()
+}
+
+// drain stops the backend's port forward once its requests in flight finish,
+// or the pool's timeout is reached.
+func (pl *pool) drain(b *backend) {
+	defer b.pf.Stop()
+
+	timeout := time.After(pl.timeout)
+	for b.active.Load() > 0 {
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-b.pf.Done():
+			return
+		case <-timeout:
+			return
+		case <-pl.ctx.Done():
+			return
+		}
+	}
+}
+
+func (pl *pool) stopAll() {
+	pl.mutex.Lock()
+	defer pl.mutex.Unlock()
+
+	for pod, b := range pl.backends {
+		b.pf.Stop()
+		delete(pl.backends, pod)
+	}
+}
+
+// acquire returns the backend to send a request to, waiting up to the pool's
+// timeout if none are available. The request must be ended by calling
+// release.
+func (pl *pool) acquire(ctx context.Context) (*backend, error) {
+	ctx, cancel := context.WithTimeout(ctx, pl.timeout)
+	defer cancel()
+
+	for {
+		pl.mutex.Lock()
+		b := pl.pick()
+		changed := pl.changed
+		if b != nil {
+			b.active.Add(1)
+		}
+		pl.mutex.Unlock()
+		if b != nil {
+			return b, nil
+		}
+
+		select {
+		case <-changed:
+		case <-pl.ctx.Done():
+			return nil, fmt.Errorf("%w: proxy is shutting down", errUnavailable)
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: timed out waiting for port forward", errUnavailable)
+		}
+	}
+}
+
+func (pl *pool) release(b *backend) {
+	b.active.Add(-1)
+}
+
+// pick returns the next backend using the pool's balancer, nil if there are
+// none. The pool's mutex must be held.
+func (pl *pool) pick() *backend {
+	var avail []*backend
+	for _, pod := range pl.pods {
+		if b := pl.backends[pod]; b != nil && !b.broken.Load() && !isDone(b.pf) {
+			avail = append(avail, b)
+		}
+	}
+	if len(avail) == 0 {
+		return nil
+	}
+
+	// Start from the next backend in turn so ties of least-conn are also
+	// spread across backends.
+	start := pl.next % len(avail)
+	pl.next++
+	picked := avail[start]
+	if pl.balance == BalanceLeastConn {
+		for i := range avail {
+			b := avail[(start+i)%len(avail)]
+			if b.active.Load() < picked.active.Load() {
+				picked = b
+			}
+		}
+	}
+
+	return picked
+}
+
+func isDone(pf *kubernetes.PortForward) bool {
+	select {
+	case <-pf.Done():
+		return true
+	default:
+		return false
+	}
+}
@@ -19,6 +19,8 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/xigxog/fox/internal/config"
@@ -32,33 +34,54 @@ type ProxyServer struct {
 	cfg  *config.Config
 	addr string
 
-	httpSrv *http.Server
-	client  http.Client
-	pf      *kubernetes.PortForward
+	httpSrv  *http.Server
+	client   http.Client
+	pool     *pool
+	recorder *recorder
+	// mirrorSem limits the mirrored requests in flight.
+	mirrorSem chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	shutdownOnce sync.Once
+	// done is closed once Shutdown completes.
+	done chan struct{}
 }
 
-func Start(port int, cfg *config.Config) {
+// Start starts the proxy on the local port and blocks until it is shut down
+// by an interrupt.
+func Start(port int, cfg *config.Config) error {
 	log.Verbose("Starting HTTP proxy server...")
 
+	ctx, cancel := context.WithCancel(context.Background())
 	srv := &ProxyServer{
-		cfg: cfg,
-		client: http.Client{
-			Timeout: time.Minute,
-			CheckRedirect: func(req *http.Request, via []*http.Request) error {
-				return http.ErrUseLastResponse
-			},
-		},
+		cfg:       cfg,
+		client:    newClient(),
+		mirrorSem: make(chan struct{}, maxMirrorsInFlight),
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
 	}
-	defer srv.Shutdown()
 
-	interruptCh := make(chan os.Signal, 1)
-	signal.Notify(interruptCh, os.Interrupt)
-	go func() {
-		<-interruptCh
-		srv.Shutdown()
-	}()
+	pl, err := startPool(srv.ctx, cfg, srv.client.Timeout)
+	if err != nil {
+		cancel()
+		return fmt.Errorf("error starting proxy: %w", err)
+	}
+	srv.pool = pl
 
-	srv.pf = srv.startPortForward(cfg)
+	if cfg.Flags.Record != "" {
+		if srv.recorder, err = newRecorder(cfg.Flags.Record, cfg.Flags.RecordBodyLimit, cfg.Flags.RecordSecrets); err != nil {
+			srv.Shutdown()
+			return fmt.Errorf("error starting proxy: %w", err)
+		}
+		go srv.recorder.flushEvery(5*time.Second, srv.ctx.Done())
+		log.Info("Recording requests to HAR file '%s'.", cfg.Flags.Record)
+	}
+	if cfg.Flags.MirrorDeployment != "" {
+		log.Info("Mirroring requests to AppDeployment '%s'.", cfg.Flags.MirrorDeployment)
+	}
 
 	srv.httpSrv = &http.Server{
 		Handler: srv,
@@ -67,13 +90,24 @@ func Start(port int, cfg *config.Config) {
 	srv.addr = fmt.Sprintf("127.0.0.1:%d", port)
 	ln, err := net.Listen("tcp", srv.addr)
 	if err != nil {
-		log.Fatal("Error starting HTTP proxy: %v", err)
+		srv.Shutdown()
+		return fmt.Errorf("error starting HTTP proxy: %w", err)
 	}
 
+	// The handler is registered once the server is created so Shutdown can
+	// drain it.
+	interruptCh := make(chan os.Signal, 1)
+	signal.Notify(interruptCh, os.Interrupt)
+	go func() {
+		<-interruptCh
+		srv.Shutdown()
+	}()
+
+	serveErr := make(chan error, 1)
 	go func() {
 		err := srv.httpSrv.Serve(ln)
 		if err != nil && !errors.Is(err, http.ErrServerClosed) {
-			log.Fatal("Error running HTTP proxy server: %v", err)
+			serveErr <- err
 		}
 	}()
 
@@ -82,16 +116,16 @@ func Start(port int, cfg *config.Config) {
 	log.Info("in your browser.")
 	log.Printf("HTTP proxy started on http://%s\n", srv.addr)
 
-	<-srv.pf.Done()
+	select {
+	case <-srv.done:
+		return nil
+	case err := <-serveErr:
+		srv.Shutdown()
+		return fmt.Errorf("error running HTTP proxy server: %w", err)
+	}
 }
 
 func (srv *ProxyServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	h := fmt.Sprintf("127.0.0.1:%d", srv.pf.LocalPort)
-	req.Host = h
-	req.URL.Host = h
-	req.URL.Scheme = "http"
-	req.RequestURI = ""
-
 	env := core.GetParamOrHeader(req, api.HeaderVirtualEnv, api.HeaderVirtualEnvAbbrv)
 	if env == "" && srv.cfg.Flags.VirtEnv != "" {
 		req.Header.Set(api.HeaderVirtualEnv, srv.cfg.Flags.VirtEnv)
@@ -101,15 +135,39 @@ func (srv *ProxyServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		req.Header.Set(api.HeaderAppDeployment, srv.cfg.Flags.AppDeployment)
 	}
 
-	reqData, _ := httputil.DumpRequest(req, false)
-	log.Verbose("Proxying request:\n%s", strings.TrimSpace(string(reqData)))
+	var ex *exchange
+	if srv.recorder != nil {
+		ex, rw = srv.recorder.start(req, rw)
+		defer srv.recorder.record(ex)
+	}
+
+	var m *mirror
+	if srv.cfg.Flags.MirrorDeployment != "" {
+		var err error
+		if m, err = srv.newMirror(req); err != nil {
+			log.Error("Error proxying request: %v", err)
+			http.Error(rw, fmt.Sprintf("fox proxy: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
 
-	resp, err := srv.client.Do(req)
+	resp, err := srv.send(req)
 	if err != nil {
 		log.Error("Error proxying request: %v", err)
+		status := http.StatusBadGateway
+		if errors.Is(err, errUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		if ex != nil {
+			ex.comment = fmt.Sprintf("request failed: %v", err)
+		}
+		http.Error(rw, fmt.Sprintf("fox proxy: %v", err), status)
 		return
 	}
 	defer resp.Body.Close()
+	if ex != nil {
+		ex.respStart = time.Now()
+	}
 
 	respData, _ := httputil.DumpResponse(resp, false)
 	log.Verbose("Got response:\n%s", strings.TrimSpace(string(respData)))
@@ -119,15 +177,90 @@ func (srv *ProxyServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	}
 	rw.WriteHeader(resp.StatusCode)
 
-	_, err = io.Copy(rw, resp.Body)
+	var w io.Writer = rw
+	if m != nil {
+		m.status = resp.StatusCode
+		w = io.MultiWriter(rw, m.respBody)
+	}
+	_, err = io.Copy(w, resp.Body)
 	if err != nil {
 		log.Error("Error writing response: %v", err)
 		rw.Write([]byte("error"))
 		return
 	}
+	if m != nil {
+		srv.sendMirror(m)
+	}
+}
+
+// send forwards the request, if the port forward breaks while the request is in
+// flight it is sent again using another port forward when safe to do so.
+func (srv *ProxyServer) send(req *http.Request) (*http.Response, error) {
+	resp, err := srv.forward(req)
+	if err != nil && isConnError(err) && canRetry(req) {
+		log.Warn("Error proxying request, retrying: %v", err)
+		resp, err = srv.forward(req)
+	}
+
+	return resp, err
 }
 
+// forward sends the request through the port forward to one of the httpsrv
+// pods. If a connection error occurs the port forward is stopped so it is
+// re-established. The returned response's body counts as in flight for
+// balancing until it is closed.
+func (srv *ProxyServer) forward(req *http.Request) (*http.Response, error) {
+	b, err := srv.pool.acquire(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	h := fmt.Sprintf("127.0.0.1:%d", b.pf.LocalPort)
+	outReq := req.Clone(req.Context())
+	outReq.Host = h
+	outReq.URL.Host = h
+	outReq.URL.Scheme = "http"
+	outReq.RequestURI = ""
+
+	reqData, _ := httputil.DumpRequest(outReq, false)
+	log.Verbose("Proxying request to pod '%s':\n%s", b.pf.Pod, strings.TrimSpace(string(reqData)))
+
+	resp, err := srv.client.Do(outReq)
+	if err != nil {
+		srv.pool.release(b)
+		if isConnError(err) {
+			srv.pool.remove(b)
+		}
+		return nil, err
+	}
+	resp.Body = &releaseBody{ReadCloser: resp.Body, release: func() { srv.pool.release(b) }}
+
+	return resp, nil
+}
+
+// releaseBody releases the backend once the response body is closed.
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *releaseBody) Close() error {
+	b.once.Do(b.release)
+	return b.ReadCloser.Close()
+}
+
+// Shutdown waits for in-flight requests to complete before stopping the port
+// forwards they use and writing the last recorded requests. It is safe to call
+// more than once, later calls return once the first completes.
 func (srv *ProxyServer) Shutdown() {
+	srv.shutdownOnce.Do(srv.shutdown)
+	<-srv.done
+}
+
+func (srv *ProxyServer) shutdown() {
+	defer close(srv.done)
+
 	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
 	defer cancel()
 
@@ -135,41 +268,68 @@ func (srv *ProxyServer) Shutdown() {
 		if err := srv.httpSrv.Shutdown(ctx); err != nil {
 			log.Error("Error shutting down HTTP proxy server: %v", err)
 		}
-		srv.httpSrv = nil
-		srv.pf.Stop()
 	}
-
+	srv.cancel()
+	if srv.pool != nil {
+		srv.pool.stopAll()
+	}
+	if srv.recorder != nil {
+		if err := srv.recorder.close(); err != nil {
+			log.Error("%v", err)
+		}
+	}
 }
 
-func (srv *ProxyServer) startPortForward(cfg *config.Config) *kubernetes.PortForward {
+// startPool creates the pool of port forwards to the httpsrv pods. The pool
+// runs until ctx is done.
+func startPool(ctx context.Context, cfg *config.Config, timeout time.Duration) (*pool, error) {
 	t := cfg.Flags.Timeout
 	if cfg.Flags.WaitTime > t {
 		t = cfg.Flags.WaitTime
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), t)
+	startCtx, cancel := context.WithTimeout(ctx, t)
 	defer cancel()
 
-	c := kubernetes.NewClient(cfg)
-	p := c.GetPlatform(ctx)
-
-	pfReq := &kubernetes.PortForwardRequest{
-		Namespace: p.Namespace,
-		Platform:  p.Name,
+	c, err := kubernetes.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	p, err := c.GetPlatform(startCtx)
+	if err != nil {
+		return nil, err
 	}
-	pf, err := c.PortForward(ctx, pfReq)
-	if errors.Is(err, kubernetes.ErrComponentNotReady) && cfg.Flags.WaitTime > 0 {
-		log.Warn("No httpsrv pod is available.")
-		log.Info("Waiting for httpsrv pod to become available...")
 
-		err = c.WaitPodReady(ctx, p, "httpsrv", "")
-		if err == nil {
-			pf, err = c.PortForward(ctx, pfReq)
-		}
+	pl := newPool(ctx, c, p, cfg.Flags.Balance, timeout)
+	if err := pl.start(startCtx, cfg.Flags.WaitTime > 0); err != nil {
+		return nil, err
 	}
-	if err != nil {
-		log.Fatal("Error starting proxy: %v", err)
+
+	return pl, nil
+}
+
+func newClient() http.Client {
+	return http.Client{
+		Timeout: time.Minute,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
 	}
+}
+
+// isConnError returns true if the error was caused by the connection to the
+// port forward failing rather than the request itself.
+func isConnError(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET)
+}
 
-	return pf
+// canRetry returns true if the request can be safely sent again. Only requests
+// with idempotent methods and no body are retried.
+func canRetry(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return req.ContentLength == 0
+	}
+	return false
 }
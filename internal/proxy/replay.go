@@ -0,0 +1,269 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/xigxog/fox/internal/config"
+	"github.com/xigxog/fox/internal/errs"
+	"github.com/xigxog/fox/internal/log"
+	"github.com/xigxog/kubefox/api"
+	"github.com/xigxog/kubefox/core"
+)
+
+// ReplayResult compares the responses to requests replayed from a HAR file with
+// the recorded responses.
+type ReplayResult struct {
+	HAR                string `json:"har"`
+	VirtualEnvironment string `json:"virtualEnvironment,omitempty"`
+	AppDeployment      string `json:"appDeployment,omitempty"`
+
+	Requests  int `json:"requests"`
+	Matched   int `json:"matched"`
+	Different int `json:"different"`
+	Failed    int `json:"failed"`
+
+	Entries []ReplayEntry `json:"entries"`
+}
+
+type ReplayEntry struct {
+	Method string `json:"method"`
+	// Path includes the query of the request.
+	Path string `json:"path"`
+
+	RecordedStatus int  `json:"recordedStatus"`
+	ReplayedStatus int  `json:"replayedStatus,omitempty"`
+	StatusMatch    bool `json:"statusMatch"`
+
+	RecordedSize int64 `json:"recordedSize"`
+	ReplayedSize int64 `json:"replayedSize"`
+	BodyMatch    bool  `json:"bodyMatch"`
+	// BodyDiffOffset is the offset of the first byte of the body that differs,
+	// -1 if the bodies match.
+	BodyDiffOffset int64 `json:"bodyDiffOffset"`
+
+	// Error is set if the request could not be replayed.
+	Error string `json:"error,omitempty"`
+}
+
+// Headers that are set by the HTTP client and not copied from the recorded
+// request.
+var skipHeaders = []string{
+	"Connection",
+	"Content-Length",
+	"Host",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func (e *ReplayEntry) Match() bool {
+	return e.Error == "" && e.StatusMatch && e.BodyMatch
+}
+
+// Replay resends the requests recorded in the HAR file, in order, through the
+// broker's HTTP server adapter and compares the responses with the recorded
+// responses. If the 'virtual-env' or 'app-deployment' flags are set they
+// replace the context of the recorded requests.
+func Replay(path string, cfg *config.Config) (*ReplayResult, error) {
+	har, err := ReadHAR(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := &ProxyServer{
+		cfg:    cfg,
+		client: newClient(),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	if srv.pool, err = startPool(ctx, cfg, srv.client.Timeout); err != nil {
+		return nil, err
+	}
+	defer srv.pool.stopAll()
+
+	res := &ReplayResult{
+		HAR:                path,
+		VirtualEnvironment: cfg.Flags.VirtEnv,
+		AppDeployment:      cfg.Flags.AppDeployment,
+		Entries:            []ReplayEntry{},
+	}
+	for i, entry := range har.Log.Entries {
+		log.Verbose("Replaying request %d of %d, %s %s", i+1, len(har.Log.Entries),
+			entry.Request.Method, entry.Request.URL)
+
+		e := srv.replay(entry)
+		switch {
+		case e.Error != "":
+			log.Warn("Error replaying %s %s: %s", e.Method, e.Path, e.Error)
+			res.Failed++
+		case e.Match():
+			res.Matched++
+		default:
+			res.Different++
+		}
+		res.Requests++
+		res.Entries = append(res.Entries, e)
+	}
+
+	return res, nil
+}
+
+// ReadHAR reads a HAR file recorded by 'fox proxy' or another tool.
+func ReadHAR(path string) (*HAR, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errs.Wrap(errs.TypeNotFound, err, "error reading HAR file")
+	}
+	har := &HAR{}
+	if err := json.Unmarshal(b, har); err != nil {
+		return nil, errs.Wrap(errs.TypeUsage, err, "error parsing HAR file '%s'", path)
+	}
+
+	return har, nil
+}
+
+// replay sends the recorded request and compares the response with the
+// recorded response.
+func (srv *ProxyServer) replay(entry HAREntry) ReplayEntry {
+	e := ReplayEntry{
+		Method:         entry.Request.Method,
+		Path:           entry.Request.URL,
+		RecordedStatus: entry.Response.Status,
+		RecordedSize:   entry.Response.Content.Size,
+		BodyDiffOffset: -1,
+	}
+
+	req, err := srv.replayRequest(entry.Request)
+	if err != nil {
+		e.Error = err.Error()
+		return e
+	}
+	e.Path = req.URL.RequestURI()
+
+	recorded, err := decodeText(entry.Response.Content.Text, entry.Response.Content.Encoding)
+	if err != nil {
+		e.Error = fmt.Sprintf("error decoding recorded response body: %v", err)
+		return e
+	}
+
+	resp, err := srv.send(req)
+	if err != nil {
+		e.Error = err.Error()
+		return e
+	}
+	defer resp.Body.Close()
+
+	// Recorded bodies may be truncated, only the recorded bytes are compared
+	// but the full size must match.
+	body := &capture{limit: len(recorded)}
+	if _, err := io.Copy(body, resp.Body); err != nil {
+		e.Error = fmt.Sprintf("error reading response body: %v", err)
+		return e
+	}
+
+	e.ReplayedStatus = resp.StatusCode
+	e.StatusMatch = e.ReplayedStatus == e.RecordedStatus
+	e.ReplayedSize = body.size
+	e.BodyDiffOffset = diffOffset(recorded, body.buf, e.RecordedSize, e.ReplayedSize)
+	e.BodyMatch = e.BodyDiffOffset < 0
+
+	return e
+}
+
+// replayRequest creates a request from the recorded request with the context
+// of the flags injected.
+func (srv *ProxyServer) replayRequest(r HARRequest) (*http.Request, error) {
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recorded URL: %w", err)
+	}
+
+	var body io.Reader = http.NoBody
+	if r.PostData != nil {
+		b, err := decodeText(r.PostData.Text, r.PostData.Encoding)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding recorded request body: %w", err)
+		}
+		if int64(len(b)) < r.BodySize {
+			return nil, fmt.Errorf("recorded request body was truncated to %d of %d bytes", len(b), r.BodySize)
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(srv.ctx, r.Method, u.RequestURI(), body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recorded request: %w", err)
+	}
+	for _, h := range r.Headers {
+		// Redacted credentials cannot be replayed.
+		if !isSkipHeader(h.Name) && !(isSecretHeader(h.Name) && h.Value == redacted) {
+			req.Header.Add(h.Name, h.Value)
+		}
+	}
+
+	if srv.cfg.Flags.VirtEnv != "" {
+		core.DelParamOrHeader(req, api.HeaderVirtualEnv, api.HeaderVirtualEnvAbbrv)
+		req.Header.Set(api.HeaderVirtualEnv, srv.cfg.Flags.VirtEnv)
+	}
+	if srv.cfg.Flags.AppDeployment != "" {
+		core.DelParamOrHeader(req, api.HeaderAppDeployment, api.HeaderAppDeploymentAbbrv)
+		req.Header.Set(api.HeaderAppDeployment, srv.cfg.Flags.AppDeployment)
+	}
+
+	return req, nil
+}
+
+func isSkipHeader(name string) bool {
+	for _, h := range skipHeaders {
+		if strings.EqualFold(h, name) {
+			return true
+		}
+	}
+	// HTTP/2 pseudo headers recorded by browsers.
+	return strings.HasPrefix(name, ":")
+}
+
+func decodeText(text, encoding string) ([]byte, error) {
+	if encoding == "base64" {
+		return base64.StdEncoding.DecodeString(text)
+	}
+	return []byte(text), nil
+}
+
+// diffOffset returns the offset of the first byte that differs between the
+// recorded and replayed bodies, -1 if they match. The bodies are prefixes of
+// the full bodies of the given sizes.
+func diffOffset(recorded, replayed []byte, recordedSize, replayedSize int64) int64 {
+	n := min(len(recorded), len(replayed))
+	for i := 0; i < n; i++ {
+		if recorded[i] != replayed[i] {
+			return int64(i)
+		}
+	}
+	if len(recorded) != len(replayed) || recordedSize != replayedSize {
+		return int64(n)
+	}
+
+	return -1
+}
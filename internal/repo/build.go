@@ -9,53 +9,105 @@
 package repo
 
 import (
-	"archive/tar"
-	"bufio"
-	"bytes"
 	"context"
-	"encoding/base64"
-	"encoding/json"
 	"fmt"
-	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"strings"
 	"time"
 
-	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/api/types/filters"
-	"github.com/docker/docker/api/types/registry"
-	"github.com/docker/docker/pkg/archive"
-	"github.com/moby/patternmatcher/ignorefile"
 	"github.com/xigxog/fox/efs"
+	"github.com/xigxog/fox/internal/errs"
 	"github.com/xigxog/fox/internal/log"
 	"github.com/xigxog/kubefox/api"
 	"github.com/xigxog/kubefox/utils"
 )
 
-const (
-	injectedDockerfile = "__Dockerfile"
-)
+// buildMeta contains the build inputs shared by all components of the app.
+// It is read from the Git repo once so that components can be built
+// concurrently.
+type buildMeta struct {
+	rootCommit string
+	headRef    string
+	tagRef     string
+	repoURL    string
+}
+
+// compBuild contains the build inputs of a single component.
+type compBuild struct {
+	dirName string
+	name    string
+	hash    string
+	image   string
+}
+
+func (r *repo) Build(compDirName string) (string, error) {
+	comp, err := r.compBuild(compDirName)
+	if err != nil {
+		return "", fmt.Errorf("error generating Component hash: %w", err)
+	}
+	meta, err := r.buildMeta()
+	if err != nil {
+		return "", err
+	}
+	if err := r.build(r.ctx, meta, comp); err != nil {
+		// Keep the type of errors such as timeouts and registry auth failures.
+		if errs.TypeOf(err) != errs.TypeGeneral {
+			return "", fmt.Errorf("error building component '%s': %w", comp.name, err)
+		}
+		return "", errs.Wrap(errs.TypeBuildFailed, err, "error building component '%s'", comp.name)
+	}
 
-type DockerfileTar struct {
-	dockerfile []byte
-	wrapped    io.ReadCloser
-	read       int
+	return comp.image, nil
 }
 
-// TODO switch to Buildah
-// https://github.com/containers/buildah/blob/e089136922680583a37e40d97e86818b09be4875/imagebuildah/build.go#L66
+func (r *repo) buildMeta() (*buildMeta, error) {
+	commit, err := r.GetCommitID()
+	if err != nil {
+		return nil, err
+	}
+	headRef, err := r.GetHeadRef()
+	if err != nil {
+		return nil, err
+	}
+	tagRef, err := r.GetTagRef()
+	if err != nil {
+		return nil, err
+	}
+
+	return &buildMeta{
+		rootCommit: commit,
+		headRef:    headRef,
+		tagRef:     tagRef,
+		repoURL:    r.GetRepoURL(),
+	}, nil
+}
 
-func (r *repo) Build(compDirName string) string {
-	img := r.GetCompImageFromDir(compDirName)
+func (r *repo) compBuild(compDirName string) (*compBuild, error) {
+	name := utils.CleanName(compDirName)
+	hash, err := r.compHash(compDirName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &compBuild{
+		dirName: compDirName,
+		name:    name,
+		hash:    hash,
+		image:   r.GetCompImage(name, hash),
+	}, nil
+}
+
+func (r *repo) build(ctx context.Context, meta *buildMeta, comp *compBuild) error {
+	l := log.FromContext(ctx)
+
+	img := comp.image
 	appYaml := r.AppYAMLBuildSubpath()
-	compName := utils.CleanName(compDirName)
-	compDir := r.ComponentBuildSubpath(compDirName)
-	compHash := r.GetCompHash(compDirName)
-	rootCommit := r.GetCommit().Hash.String()
-	headRef := r.GetHeadRef()
-	tagRef := r.GetTagRef()
+	compName := comp.name
+	compDir := r.ComponentBuildSubpath(comp.dirName)
+	compHash := comp.hash
+	rootCommit := meta.rootCommit
+	headRef := meta.headRef
+	tagRef := meta.tagRef
 	now := time.Now().Format(time.RFC3339)
 
 	buildArgs := map[string]*string{
@@ -68,63 +120,65 @@ func (r *repo) Build(compDirName string) string {
 		"HEAD_REF":       &headRef,
 		"TAG_REF":        &tagRef,
 	}
-	log.VerboseMarshal(buildArgs, "Docker build args:")
+	l.VerboseMarshal(buildArgs, "Build args:")
 
 	if !(r.cfg.Flags.ForceBuild || r.cfg.Flags.NoCache) {
-		if found, _ := r.DoesImageExists(img, false); found {
-			log.Info("Component image '%s' exists, skipping build.", img)
+		if found, _ := r.doesImageExists(ctx, img, false); found {
+			l.Info("Component image '%s' exists, skipping build.", img)
 			if r.cfg.Flags.PushImage {
-				r.PushImage(img)
+				return r.pushImage(ctx, img)
 			}
 
-			return img
+			return nil
 		}
 	}
 
-	log.Info("Building component image '%s'.", img)
-	dfPath := filepath.Join(r.ComponentDir(compDirName), "Dockerfile")
+	l.Info("Building component image '%s'.", img)
+	dfPath := filepath.Join(r.ComponentDir(comp.dirName), "Dockerfile")
 	df, err := os.ReadFile(dfPath)
-	if err != nil {
-		log.Verbose("Using default Dockerfile for build")
+	customDf := err == nil
+	if !customDf {
+		l.Verbose("Using default Dockerfile for build")
 		df, _ = efs.EFS.ReadFile("Dockerfile")
 	} else {
-		log.Verbose("Using custom Dockerfile '%s' for build", dfPath)
+		l.Verbose("Using custom Dockerfile '%s' for build", dfPath)
 	}
 
-	dfi, err := NewDFI(r.cfg.RepoPath, df)
-	if err != nil {
-		log.Fatal("Error creating container tar: %v", err)
-	}
 	labels := map[string]string{
 		api.LabelOCIComponent: compName,
 		api.LabelOCICreated:   now,
 		api.LabelOCIRevision:  compHash,
-		api.LabelOCISource:    r.GetRepoURL(),
+		api.LabelOCISource:    meta.repoURL,
 	}
 
-	buildResp, err := r.docker.ImageBuild(r.ctx, dfi, types.ImageBuildOptions{
-		Dockerfile: injectedDockerfile,
-		NoCache:    r.cfg.Flags.NoCache,
-		Remove:     true,
-		Tags:       []string{img},
-		Labels:     labels,
-		BuildArgs:  buildArgs,
+	err = r.builder.Build(ctx, &BuildRequest{
+		Image:            img,
+		RepoPath:         r.cfg.RepoPath,
+		ComponentDir:     compDir,
+		Dockerfile:       df,
+		CustomDockerfile: customDf,
+		BuildArgs:        buildArgs,
+		Labels:           labels,
+		NoCache:          r.cfg.Flags.NoCache,
 	})
 	if err != nil {
-		log.Fatal("Error building container image: %v", err)
+		return fmt.Errorf("error building container image: %w", err)
 	}
-	logResp(buildResp.Body, true)
 
 	if r.cfg.Flags.PushImage {
-		r.PushImage(img)
+		return r.pushImage(ctx, img)
 	}
 
-	return img
+	return nil
 }
 
 func (r *repo) DoesImageExists(img string, pull bool) (bool, error) {
+	return r.doesImageExists(r.ctx, img, pull)
+}
+
+func (r *repo) doesImageExists(ctx context.Context, img string, pull bool) (bool, error) {
 	if r.cfg.IsRegistryLocal() {
-		found := r.IsImageLocal(img)
+		found := r.isImageLocal(ctx, img)
 		if !found && pull {
 			return false, fmt.Errorf("component image does not exist locally and no remote registry available")
 		}
@@ -132,24 +186,13 @@ func (r *repo) DoesImageExists(img string, pull bool) (bool, error) {
 		return found, nil
 	}
 
-	if di, err := r.docker.DistributionInspect(r.ctx, img, r.GetRegAuth()); err != nil {
-		log.Verbose("%s", err)
+	if found, err := r.builder.IsImageRemote(ctx, img); !found {
 		return false, err
+	}
 
-	} else {
-		log.Verbose("Digest: %s", di.Descriptor.Digest)
-
-		if pull && !r.IsImageLocal(img) {
-			pullResp, err := r.docker.ImagePull(r.ctx, img, types.ImagePullOptions{
-				RegistryAuth: r.GetRegAuth(),
-			})
-			if err != nil {
-				return false, fmt.Errorf("error pulling component image: %v", err)
-			}
-			if err := logResp(pullResp, false); err != nil {
-				return false, fmt.Errorf("error pulling component image: %v", err)
-			}
-			return true, nil
+	if pull && !r.isImageLocal(ctx, img) {
+		if err := r.builder.Pull(ctx, img); err != nil {
+			return false, fmt.Errorf("error pulling component image: %v", err)
 		}
 	}
 
@@ -157,174 +200,76 @@ func (r *repo) DoesImageExists(img string, pull bool) (bool, error) {
 }
 
 func (r *repo) IsImageLocal(img string) bool {
-	l, _ := r.docker.ImageList(context.Background(), types.ImageListOptions{
-		Filters: filters.NewArgs(filters.Arg("reference", img)),
-	})
+	return r.isImageLocal(r.ctx, img)
+}
 
-	found := len(l) > 0
+func (r *repo) isImageLocal(ctx context.Context, img string) bool {
+	found := r.builder.IsImageLocal(ctx, img)
 	if found {
-		log.Verbose("Image '%s' found locally.", img)
+		log.FromContext(ctx).Verbose("Image '%s' found locally.", img)
 	} else {
-		log.Verbose("Image '%s' not found locally.", img)
+		log.FromContext(ctx).Verbose("Image '%s' not found locally.", img)
 	}
 
 	return found
 }
 
-func (r *repo) PushImage(img string) {
-	if r.cfg.Flags.Generate {
-		return
+func (r *repo) PushImage(img string) error {
+	if err := r.pushImage(r.ctx, img); err != nil {
+		return fmt.Errorf("error publishing component image '%s': %w", img, err)
 	}
 
-	if !r.cfg.IsRegistryLocal() {
-		log.Info("Pushing component image to registry '%s'.", img)
-
-		pushResp, err := r.docker.ImagePush(r.ctx, img, types.ImagePushOptions{
-			RegistryAuth: r.GetRegAuth(),
-		})
-		if err != nil {
-			log.Fatal("Error pushing container image: %v", err)
-		}
-
-		logResp(pushResp, true)
-	}
-
-	r.PushKind(img)
+	return nil
 }
 
-func (r *repo) PushKind(img string) {
+func (r *repo) pushImage(ctx context.Context, img string) error {
 	if r.cfg.Flags.Generate {
-		return
+		return nil
 	}
 
-	kind := r.cfg.Flags.Kind
-	if kind == "" && r.cfg.Kind.AlwaysLoad {
-		kind = r.cfg.Kind.ClusterName
-	}
-	if kind == "" {
-		return
-	}
+	if !r.cfg.IsRegistryLocal() {
+		log.FromContext(ctx).Info("Pushing component image to registry '%s'.", img)
 
-	log.Info("Loading component image '%s' into kind cluster '%s'.", img, kind)
-	if found, err := r.DoesImageExists(img, true); !found {
-		if err != nil {
-			log.Fatal("Error loading component image into kind: %v", err)
+		if err := r.builder.Push(ctx, img); err != nil {
+			return fmt.Errorf("error pushing container image: %w", err)
 		}
-		log.Fatal("Component image does not exist, please build it first.")
 	}
 
-	cmd := exec.Command("kind", "load", "docker-image", "--name="+kind, img)
-	if out, err := cmd.CombinedOutput(); err != nil {
-		log.Error("%s", strings.TrimSpace(string(out)))
-		log.Fatal("Error loading component image into kind: %v", err)
-	} else {
-		log.Verbose("%s", strings.TrimSpace(string(out)))
-	}
+	return r.pushKind(ctx, img)
 }
 
-func (r *repo) GetRegAuth() string {
-	token := r.cfg.GetContainerRegistry().Token
-	if r.cfg.GitHub.Token != "" {
-		token = r.cfg.GitHub.Token
-	}
-	user := r.cfg.GetContainerRegistry().Username
-	if user == "" {
-		user = "kubefox"
-	}
-	authCfg, _ := json.Marshal(registry.AuthConfig{
-		Username: user,
-		Password: token,
-	})
-
-	return base64.StdEncoding.EncodeToString(authCfg)
-}
-
-func logResp(resp io.ReadCloser, fatal bool) error {
-	defer resp.Close()
-
-	scanner := bufio.NewScanner(resp)
-	for scanner.Scan() {
-		l := make(map[string]any)
-		json.Unmarshal(scanner.Bytes(), &l)
-		logLine(l, "stream")
-		logLine(l, "status", "id")
-		if s, f := l["error"]; f {
-			if fatal {
-				log.Fatal("%s", s)
-			} else {
-				return fmt.Errorf("%s", s)
-			}
-		}
+func (r *repo) PushKind(img string) error {
+	if err := r.pushKind(r.ctx, img); err != nil {
+		return fmt.Errorf("error publishing component image '%s': %w", img, err)
 	}
 
 	return nil
 }
 
-func logLine(l map[string]any, keys ...string) {
-	var msg string
-	for _, k := range keys {
-		if s, f := l[k]; f {
-			if msg == "" {
-				msg = fmt.Sprintf("%s", s)
-			} else {
-				msg = fmt.Sprintf("%s %s", msg, s)
-			}
+func (r *repo) pushKind(ctx context.Context, img string) error {
+	if r.cfg.Flags.Generate {
+		return nil
+	}
 
-		}
+	kind := r.cfg.Flags.Kind
+	if kind == "" && r.cfg.Kind.AlwaysLoad {
+		kind = r.cfg.Kind.ClusterName
 	}
-	msg = strings.ReplaceAll(msg, "\n", "")
-	if strings.TrimSpace(msg) != "" {
-		log.Verbose("%s", msg)
+	if kind == "" {
+		return nil
 	}
-}
 
-func NewDFI(path string, df []byte) (*DockerfileTar, error) {
-	var buf bytes.Buffer
-	w := tar.NewWriter(&buf)
-	w.WriteHeader(&tar.Header{
-		Typeflag: tar.TypeReg,
-		Name:     injectedDockerfile,
-		Size:     int64(len(df)),
-		Mode:     644,
-		ModTime:  time.Time{},
-	})
-	w.Write(df)
-	w.Flush()
-
-	dif, err := os.Open(filepath.Join(path, ".dockerignore"))
-	if err != nil && !os.IsNotExist(err) {
-		return nil, err
-	}
-	ignore := []string{".git"}
-	if dif != nil {
-		ignore, err = ignorefile.ReadAll(dif)
+	log.FromContext(ctx).Info("Loading component image '%s' into kind cluster '%s'.", img, kind)
+	if found, err := r.doesImageExists(ctx, img, true); !found {
 		if err != nil {
-			return nil, err
+			return fmt.Errorf("error loading component image into kind: %w", err)
 		}
-	}
-	tar, err := archive.TarWithOptions(path, &archive.TarOptions{
-		ExcludePatterns: ignore,
-	})
-	if err != nil {
-		return nil, err
+		return fmt.Errorf("component image does not exist, please build it first")
 	}
 
-	return &DockerfileTar{
-		wrapped:    tar,
-		dockerfile: buf.Bytes(),
-	}, nil
-}
-
-func (dfi *DockerfileTar) Read(p []byte) (n int, err error) {
-	if dfi.read < len(dfi.dockerfile) {
-		c := copy(p, dfi.dockerfile)
-		dfi.read = dfi.read + c
-		return c, nil
+	if err := r.builder.LoadKind(ctx, img, kind); err != nil {
+		return fmt.Errorf("error loading component image into kind: %w", err)
 	}
 
-	return dfi.wrapped.Read(p)
-}
-
-func (dfi *DockerfileTar) Close() error {
-	return dfi.wrapped.Close()
+	return nil
 }
@@ -0,0 +1,214 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package repo
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/xigxog/fox/internal/config"
+	"github.com/xigxog/fox/internal/log"
+)
+
+// buildahBuilder uses the buildah CLI to build images without a Docker
+// daemon. Images are stored in the local containers-storage of the user.
+type buildahBuilder struct {
+	cfg *config.Config
+}
+
+func newBuildahBuilder(cfg *config.Config) (*buildahBuilder, error) {
+	if _, err := exec.LookPath("buildah"); err != nil {
+		return nil, fmt.Errorf("buildah builder requires the 'buildah' CLI: %w", err)
+	}
+
+	return &buildahBuilder{cfg: cfg}, nil
+}
+
+func (b *buildahBuilder) Build(ctx context.Context, req *BuildRequest) error {
+	tmp, err := os.MkdirTemp("", "fox-buildah-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	// Dockerfile is placed outside of the build context so it is not part of
+	// the build.
+	dfPath := filepath.Join(tmp, "Dockerfile")
+	if err := os.WriteFile(dfPath, req.Dockerfile, 0644); err != nil {
+		return err
+	}
+	// Use the same ignore patterns as the Docker builder, which excludes the
+	// Git dir if the repo has no .dockerignore file.
+	ignore, err := readIgnorePatterns(os.DirFS(req.RepoPath))
+	if err != nil {
+		return err
+	}
+	ignorePath := filepath.Join(tmp, "ignore")
+	if err := os.WriteFile(ignorePath, []byte(strings.Join(ignore, "\n")), 0644); err != nil {
+		return err
+	}
+
+	args := []string{"build", "--file", dfPath, "--ignorefile", ignorePath, "--tag", req.Image, "--layers"}
+	if req.NoCache {
+		args = append(args, "--no-cache")
+	}
+	for _, k := range sortedKeys(req.BuildArgs) {
+		if v := req.BuildArgs[k]; v != nil {
+			args = append(args, "--build-arg", fmt.Sprintf("%s=%s", k, *v))
+		}
+	}
+	for _, k := range sortedKeys(req.Labels) {
+		args = append(args, "--label", fmt.Sprintf("%s=%s", k, req.Labels[k]))
+	}
+	args = append(args, req.RepoPath)
+
+	_, err = runCmd(ctx, "buildah", args...)
+	return err
+}
+
+func (b *buildahBuilder) IsImageLocal(ctx context.Context, img string) bool {
+	_, err := exec.CommandContext(ctx, "buildah", "inspect", "--type", "image", img).Output()
+	return err == nil
+}
+
+func (b *buildahBuilder) IsImageRemote(ctx context.Context, img string) (bool, error) {
+	return remoteImageExists(ctx, b.cfg, img)
+}
+
+func (b *buildahBuilder) Pull(ctx context.Context, img string) error {
+	return b.runWithCreds(ctx, img, "pull", "--quiet", img)
+}
+
+func (b *buildahBuilder) Push(ctx context.Context, img string) error {
+	return b.runWithCreds(ctx, img, "push", img, "docker://"+img)
+}
+
+func (b *buildahBuilder) LoadKind(ctx context.Context, img, cluster string) error {
+	tmp, err := os.MkdirTemp("", "fox-buildah-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	archive := filepath.Join(tmp, "image.tar")
+	if _, err := runCmd(ctx, "buildah", "push", img, fmt.Sprintf("docker-archive:%s:%s", archive, img)); err != nil {
+		return err
+	}
+
+	_, err = runCmd(ctx, "kind", "load", "image-archive", "--name="+cluster, archive)
+	return err
+}
+
+func (b *buildahBuilder) ExportCompDef(ctx context.Context, img string) ([]byte, error) {
+	out, err := exec.CommandContext(ctx, "buildah", "inspect", "--type", "image",
+		"--format", "{{json .OCIv1.Config.Entrypoint}}", img).Output()
+	if err != nil {
+		return nil, fmt.Errorf("error inspecting image: %w", cmdErr(err))
+	}
+	entrypoint := []string{}
+	if err := json.Unmarshal(bytes.TrimSpace(out), &entrypoint); err != nil {
+		return nil, fmt.Errorf("error reading image entrypoint: %w", err)
+	}
+	if len(entrypoint) == 0 {
+		return nil, fmt.Errorf("image '%s' does not define an entrypoint", img)
+	}
+
+	out, err = exec.CommandContext(ctx, "buildah", "from", "--quiet", "--pull=never", img).Output()
+	if err != nil {
+		return nil, fmt.Errorf("error creating working container: %w", cmdErr(err))
+	}
+	ctr := strings.TrimSpace(string(out))
+	defer func() {
+		if _, err := runCmd(context.Background(), "buildah", "rm", ctr); err != nil {
+			log.FromContext(ctx).Error("Error removing component container: %v", err)
+		}
+	}()
+
+	args := append([]string{"run", ctr, "--"}, entrypoint...)
+	args = append(args, "-export")
+	out, err = exec.CommandContext(ctx, "buildah", args...).Output()
+	if err != nil {
+		return nil, cmdErr(err)
+	}
+
+	return out, nil
+}
+
+// runWithCreds runs the buildah subcommand with the registry credentials of
+// img. Credentials are passed in a temporary auth file readable only by the
+// user so they are not visible in the process list or logs.
+func (b *buildahBuilder) runWithCreds(ctx context.Context, img string, args ...string) error {
+	user, token := regCreds(b.cfg)
+	if token == "" || b.cfg.IsRegistryLocal() {
+		_, err := runCmd(ctx, "buildah", args...)
+		return err
+	}
+
+	ref, err := name.ParseReference(img)
+	if err != nil {
+		return err
+	}
+	auth, err := json.Marshal(map[string]any{
+		"auths": map[string]any{
+			ref.Context().RegistryStr(): map[string]string{
+				"auth": base64.StdEncoding.EncodeToString([]byte(user + ":" + token)),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// CreateTemp creates the file with mode 0600.
+	f, err := os.CreateTemp("", "fox-buildah-auth-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(auth)
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return fmt.Errorf("error writing registry auth file: %w", err)
+	}
+
+	// Insert auth file after subcommand.
+	args = append([]string{args[0], "--authfile", f.Name()}, args[1:]...)
+	_, err = runCmd(ctx, "buildah", args...)
+	return err
+}
+
+// cmdErr adds the stderr of a failed command to the error.
+func cmdErr(err error) error {
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+
+	return err
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
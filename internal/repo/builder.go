@@ -0,0 +1,117 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package repo
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/xigxog/fox/internal/config"
+	"github.com/xigxog/fox/internal/log"
+)
+
+const (
+	BuilderDocker  = "docker"
+	BuilderBuildah = "buildah"
+	BuilderNative  = "native"
+)
+
+var (
+	Builders = []string{BuilderDocker, BuilderBuildah, BuilderNative}
+)
+
+// Builder is responsible for building component images and moving them
+// between the local image store, the container registry, and kind.
+type Builder interface {
+	Build(ctx context.Context, req *BuildRequest) error
+
+	IsImageLocal(ctx context.Context, img string) bool
+	IsImageRemote(ctx context.Context, img string) (bool, error)
+	Pull(ctx context.Context, img string) error
+	Push(ctx context.Context, img string) error
+	LoadKind(ctx context.Context, img, cluster string) error
+
+	// ExportCompDef runs the component image with the '-export' flag and
+	// returns the JSON encoded component definition it writes to stdout.
+	ExportCompDef(ctx context.Context, img string) ([]byte, error)
+}
+
+type BuildRequest struct {
+	Image string
+
+	// RepoPath is the root of the Git repo and is used as the build context.
+	RepoPath string
+	// ComponentDir is the path of the component relative to RepoPath.
+	ComponentDir string
+	// Dockerfile contains the contents of the Dockerfile to build with.
+	Dockerfile []byte
+	// CustomDockerfile is true if the component provided its own Dockerfile
+	// instead of using the KubeFox default.
+	CustomDockerfile bool
+
+	BuildArgs map[string]*string
+	Labels    map[string]string
+	NoCache   bool
+}
+
+func NewBuilder(cfg *config.Config) (Builder, error) {
+	switch strings.ToLower(cfg.Flags.Builder) {
+	case BuilderDocker, "":
+		return newDockerBuilder(cfg)
+	case BuilderBuildah:
+		return newBuildahBuilder(cfg)
+	case BuilderNative:
+		return newNativeBuilder(cfg)
+	default:
+		return nil, fmt.Errorf("unknown builder '%s', provide one of: '%s'",
+			cfg.Flags.Builder, strings.Join(Builders, "', '"))
+	}
+}
+
+// Flags whose values are credentials and are not logged.
+var secretFlags = []string{"--creds", "--password", "--registry-token"}
+
+// redactArgs returns a copy of args with the values of secretFlags replaced.
+func redactArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	for i, a := range args {
+		redacted[i] = a
+		for _, f := range secretFlags {
+			switch {
+			case i > 0 && args[i-1] == f:
+				redacted[i] = "REDACTED"
+			case strings.HasPrefix(a, f+"="):
+				redacted[i] = f + "=REDACTED"
+			}
+		}
+	}
+
+	return redacted
+}
+
+func runCmd(ctx context.Context, name string, args ...string) ([]byte, error) {
+	l := log.FromContext(ctx)
+	l.Verbose("Running command '%s %s'", name, strings.Join(redactArgs(args), " "))
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if s := strings.TrimSpace(string(out)); s != "" {
+			return out, fmt.Errorf("%w: %s", err, s)
+		}
+		return out, err
+	}
+	if s := strings.TrimSpace(string(out)); s != "" {
+		l.Verbose("%s", s)
+	}
+
+	return out, nil
+}
@@ -12,15 +12,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/docker/docker/api/types/container"
 	"github.com/xigxog/fox/internal/log"
-	foxutils "github.com/xigxog/fox/internal/utils"
 	"github.com/xigxog/kubefox/api"
 	"github.com/xigxog/kubefox/api/kubernetes/v1alpha1"
 	"github.com/xigxog/kubefox/k8s"
@@ -29,52 +27,77 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-func (r *repo) Deploy(skipImageCheck bool) *v1alpha1.AppDeployment {
-	var name string
-	switch {
-	case r.cfg.Flags.AppDeployment != "":
-		name = r.cfg.Flags.AppDeployment
-	case r.cfg.Flags.Version != "":
-		name = utils.CleanName(fmt.Sprintf("%s-%s", r.app.Name, utils.CleanName(r.cfg.Flags.Version)))
-	default:
-		switch {
-		case r.GetHeadRef() != "":
-			name = utils.CleanName(fmt.Sprintf("%s-%s", r.app.Name, utils.CleanName(r.GetHeadRef())))
-		case r.GetTagRef() != "":
-			name = utils.CleanName(fmt.Sprintf("%s-%s", r.app.Name, utils.CleanName(r.GetTagRef())))
-		default:
-			name = utils.CleanName(fmt.Sprintf("%s-%s", r.app.Name, r.GetCommit().Hash.String()))
-		}
+func (r *repo) Deploy(skipImageCheck bool) (*v1alpha1.AppDeployment, error) {
+	name, err := r.appDepName()
+	if err != nil {
+		return nil, err
 	}
 
-	if r.cfg.Flags.CreateTag && !strings.HasSuffix(r.GetTagRef(), r.cfg.Flags.Version) {
-		r.CreateTag(r.cfg.Flags.Version)
+	var createTag bool
+	if r.cfg.Flags.CreateTag {
+		tagRef, err := r.GetTagRef()
+		if err != nil {
+			return nil, err
+		}
+		createTag = !strings.HasSuffix(tagRef, r.cfg.Flags.Version)
 	}
 
-	appDep := r.prepareDeployment(skipImageCheck)
+	appDep, err := r.prepareDeployment(skipImageCheck)
+	if err != nil {
+		return nil, err
+	}
 	appDep.ObjectMeta.Name = name
+	// The tag is created once the changes are confirmed.
+	if createTag {
+		appDep.Spec.Tag = r.cfg.Flags.Version
+	}
 
 	// Check if only need to generate AppDeployment.
 	if r.cfg.Flags.Generate {
-		return appDep
+		if createTag {
+			if _, err := r.CreateTag(r.cfg.Flags.Version); err != nil {
+				return nil, err
+			}
+		}
+		return appDep, nil
 	}
 
-	p := r.k8s.GetPlatform(r.ctx)
+	p, err := r.k8s.GetPlatform(r.ctx)
+	if err != nil {
+		return nil, err
+	}
 	appDep.ObjectMeta.Namespace = p.Namespace
-
-	r.applyIPS(r.ctx, p, &appDep.Spec)
+	appDep.Spec.ImagePullSecretName = r.imagePullSecretName(appDep.Spec.AppName)
 
 	log.VerboseMarshal(appDep, "AppDeployment:")
 
+	// Nothing is changed until the changes are confirmed.
+	if r.cfg.Flags.Diff {
+		if err := r.confirmDiff(appDep); err != nil {
+			return nil, err
+		}
+	}
+
+	if createTag {
+		if _, err := r.CreateTag(r.cfg.Flags.Version); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.applyIPS(r.ctx, p, appDep.Spec.AppName); err != nil {
+		return nil, err
+	}
+
 	if err := r.k8s.Merge(r.ctx, appDep, nil); err != nil {
-		log.Fatal("%v", err)
+		return nil, err
 	}
 
-	r.waitForReady(p, &appDep.Spec)
+	if err := r.waitForReady(p, appDep, nil); err != nil {
+		return nil, err
+	}
 
 	// Get updated status.
 	if err := r.k8s.Get(r.ctx, k8s.Key(appDep.Namespace, appDep.Name), appDep); err != nil {
-		log.Fatal("Error getting updated AppDeployment: %v", err)
+		return nil, fmt.Errorf("error getting updated AppDeployment: %w", err)
 	}
 
 	appDep.TypeMeta = metav1.TypeMeta{
@@ -82,34 +105,124 @@ func (r *repo) Deploy(skipImageCheck bool) *v1alpha1.AppDeployment {
 		Kind:       "AppDeployment",
 	}
 
-	return appDep
+	return appDep, nil
 }
 
-func (r *repo) Publish() *v1alpha1.AppDeployment {
+// appDepName returns the name of the AppDeployment for the currently checked
+// out commit.
+func (r *repo) appDepName() (string, error) {
+	switch {
+	case r.cfg.Flags.AppDeployment != "":
+		return r.cfg.Flags.AppDeployment, nil
+	case r.cfg.Flags.Version != "":
+		return utils.CleanName(fmt.Sprintf("%s-%s", r.app.Name, utils.CleanName(r.cfg.Flags.Version))), nil
+	}
+
+	headRef, err := r.GetHeadRef()
+	if err != nil {
+		return "", err
+	}
+	tagRef, err := r.GetTagRef()
+	if err != nil {
+		return "", err
+	}
+
+	var name string
+	switch {
+	case headRef != "":
+		name = utils.CleanName(fmt.Sprintf("%s-%s", r.app.Name, utils.CleanName(headRef)))
+	case tagRef != "":
+		name = utils.CleanName(fmt.Sprintf("%s-%s", r.app.Name, utils.CleanName(tagRef)))
+	default:
+		c, err := r.GetCommit()
+		if err != nil {
+			return "", err
+		}
+		name = utils.CleanName(fmt.Sprintf("%s-%s", r.app.Name, c.Hash.String()))
+	}
+	// Uncommitted changes are deployed separately so they are never
+	// confused with the commit they are based on.
+	if dirty, err := r.GetDirtyCommit(); err != nil {
+		return "", err
+	} else if dirty != nil {
+		name = utils.CleanName(name + "-" + dirtyCommitPrefix)
+	}
+
+	return name, nil
+}
+
+func (r *repo) Publish() (*v1alpha1.AppDeployment, error) {
 	compsDir, err := os.ReadDir(r.ComponentsDir())
 	if err != nil {
-		log.Fatal("Error listing components dir '%s': %v", r.ComponentsDir(), err)
+		return nil, fmt.Errorf("error listing components dir '%s': %w", r.ComponentsDir(), err)
 	}
 
+	meta, err := r.buildMeta()
+	if err != nil {
+		return nil, err
+	}
+	comps := []*compBuild{}
 	for _, compDir := range compsDir {
 		if !compDir.IsDir() {
 			continue
 		}
-		r.Build(compDir.Name())
-		log.InfoNewline()
+		comp, err := r.compBuild(compDir.Name())
+		if err != nil {
+			return nil, fmt.Errorf("error generating Component hash: %w", err)
+		}
+		comps = append(comps, comp)
 	}
 
+	var failed int
+	for i, err := range r.buildAll(meta, comps) {
+		if err != nil {
+			failed++
+			log.Error("Component '%s' failed: %v", comps[i].name, err)
+		} else {
+			log.Info("Component '%s' succeeded: %s", comps[i].name, comps[i].image)
+		}
+	}
+	if failed > 0 {
+		return nil, fmt.Errorf("%w: %d of %d components failed", ErrBuildFailed, failed, len(comps))
+	}
+	log.InfoNewline()
+
 	if !r.cfg.Flags.SkipDeploy {
 		return r.Deploy(true)
 	}
 
-	return nil
+	return nil, nil
 }
 
-func (r *repo) applyIPS(ctx context.Context, p *v1alpha1.Platform, spec *v1alpha1.AppDeploymentSpec) {
+// buildAll builds the components using up to the configured number of
+// parallel workers. Output of each build is prefixed with the component name.
+// All components are built even if some fail, the error of each build is
+// returned in the same order as comps.
+func (r *repo) buildAll(meta *buildMeta, comps []*compBuild) []error {
+	errs := make([]error, len(comps))
+	sem := make(chan struct{}, max(r.cfg.Flags.Parallel, 1))
+	wg := sync.WaitGroup{}
+	for i, comp := range comps {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			ctx := log.NewContext(r.ctx, log.WithPrefix(fmt.Sprintf("[%s] ", comp.name)))
+			errs[i] = r.build(ctx, meta, comp)
+		}()
+	}
+	wg.Wait()
+
+	return errs
+}
+
+func (r *repo) applyIPS(ctx context.Context, p *v1alpha1.Platform, appName string) error {
 	cr := r.cfg.GetContainerRegistry()
-	if cr.Token != "" {
-		name := fmt.Sprintf("%s-image-pull-secret", spec.AppName)
+	if name := r.imagePullSecretName(appName); name != "" {
 		user := cr.Username
 		if user == "" {
 			user = "kubefox"
@@ -133,17 +246,31 @@ func (r *repo) applyIPS(ctx context.Context, p *v1alpha1.Platform, spec *v1alpha
 		}
 
 		if err := r.k8s.Apply(ctx, s); err != nil {
-			log.Fatal("%v", err)
+			return fmt.Errorf("error applying image pull secret: %w", err)
 		}
-		spec.ImagePullSecretName = name
 	}
+
+	return nil
+}
+
+// imagePullSecretName returns the name of the app's image pull secret, or an
+// empty string if the container registry does not require one.
+func (r *repo) imagePullSecretName(appName string) string {
+	if r.cfg.GetContainerRegistry().Token == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("%s-image-pull-secret", appName)
 }
 
 // prepareDeployment pulls the Platform, generates the AppDeploymentSpec and
 // ensures all images exist. If there are any issues it will prompt the user to
 // correct them.
-func (r *repo) prepareDeployment(skipImageCheck bool) *v1alpha1.AppDeployment {
-	appDep := r.buildAppDep()
+func (r *repo) prepareDeployment(skipImageCheck bool) (*v1alpha1.AppDeployment, error) {
+	appDep, err := r.buildAppDep()
+	if err != nil {
+		return nil, err
+	}
 
 	if !skipImageCheck {
 		allFound := true
@@ -151,7 +278,9 @@ func (r *repo) prepareDeployment(skipImageCheck bool) *v1alpha1.AppDeployment {
 			img := r.GetCompImage(n, c.Hash)
 			if found, _ := r.DoesImageExists(img, false); found {
 				log.Info("Component image '%s' exists.", img)
-				r.PushKind(img)
+				if err := r.pushKind(r.ctx, img); err != nil {
+					return nil, fmt.Errorf("error publishing component image '%s': %w", img, err)
+				}
 			} else {
 				log.Warn("Component image '%s' does not exist.", img)
 				allFound = false
@@ -162,30 +291,50 @@ func (r *repo) prepareDeployment(skipImageCheck bool) *v1alpha1.AppDeployment {
 		if !allFound {
 			log.Info("There are one or more missing component images. 🦊 Fox will need to build them")
 			log.Info("before continuing with the operation.")
-			if foxutils.YesNoPrompt("Missing component images, would you like to build them?", true) {
-				log.InfoNewline()
-				r.Publish()
-			} else {
-				log.Fatal("There are one or more missing component images.")
+			if !r.cfg.Prompter().YesNo("Missing component images, would you like to build them?", true) {
+				return nil, ErrMissingImages
+			}
+			log.InfoNewline()
+			if _, err := r.Publish(); err != nil {
+				return nil, err
 			}
 		}
 	}
 
 	for compName, comp := range appDep.Spec.Components {
 		if err := r.extractCompDef(compName, comp); err != nil {
-			log.Fatal("Error getting component '%s' definition: %v", compName, err)
+			return nil, fmt.Errorf("error getting component '%s' definition: %w", compName, err)
 		}
 	}
 
-	return appDep
+	return appDep, nil
 }
 
-func (r *repo) buildAppDep() *v1alpha1.AppDeployment {
+func (r *repo) buildAppDep() (*v1alpha1.AppDeployment, error) {
 	compsDir, err := os.ReadDir(r.ComponentsDir())
 	if err != nil {
-		log.Fatal("Error listing components dir '%s': %v", r.ComponentsDir(), err)
+		return nil, fmt.Errorf("error listing components dir '%s': %w", r.ComponentsDir(), err)
+	}
+	commit, err := r.GetCommit()
+	if err != nil {
+		return nil, err
+	}
+	commitID, err := r.GetCommitID()
+	if err != nil {
+		return nil, err
+	}
+	dirty, err := r.GetDirtyCommit()
+	if err != nil {
+		return nil, err
+	}
+	headRef, err := r.GetHeadRef()
+	if err != nil {
+		return nil, err
+	}
+	tagRef, err := r.GetTagRef()
+	if err != nil {
+		return nil, err
 	}
-	commit := r.GetCommit()
 	reg := r.app.ContainerRegistry
 	if reg == "" {
 		reg = r.cfg.GetContainerRegistry().Address
@@ -198,12 +347,12 @@ func (r *repo) buildAppDep() *v1alpha1.AppDeployment {
 		},
 		Spec: v1alpha1.AppDeploymentSpec{
 			AppName:           r.app.Name,
-			Commit:            commit.Hash.String(),
+			Commit:            commitID,
 			CommitTime:        metav1.NewTime(commit.Committer.When),
 			Version:           r.cfg.Flags.Version,
 			RepoURL:           r.GetRepoURL(),
-			Branch:            filepath.Base(r.GetHeadRef()),
-			Tag:               filepath.Base(r.GetTagRef()),
+			Branch:            filepath.Base(headRef),
+			Tag:               filepath.Base(tagRef),
 			ContainerRegistry: reg,
 			Components:        map[string]*api.ComponentDefinition{},
 		},
@@ -215,56 +364,35 @@ func (r *repo) buildAppDep() *v1alpha1.AppDeployment {
 		},
 	}
 
+	if dirty != nil {
+		appDep.Labels = map[string]string{
+			LabelDirty:           "true",
+			LabelDirtyBaseCommit: dirty.Base,
+			LabelDirtyDiffDigest: dirty.Digest,
+		}
+	}
+
 	for _, compDir := range compsDir {
 		if !compDir.IsDir() {
 			continue
 		}
-		compName := utils.CleanName(compDir.Name())
-		appDep.Spec.Components[compName] = &api.ComponentDefinition{
-			Hash: r.GetCompHash(compDir.Name()),
+		hash, err := r.compHash(compDir.Name())
+		if err != nil {
+			return nil, fmt.Errorf("error generating Component hash: %w", err)
+		}
+		appDep.Spec.Components[utils.CleanName(compDir.Name())] = &api.ComponentDefinition{
+			Hash: hash,
 		}
 	}
 
-	return appDep
+	return appDep, nil
 }
 
 func (r *repo) extractCompDef(compName string, comp *api.ComponentDefinition) error {
 	hash := comp.Hash
 	img := r.GetCompImage(compName, comp.Hash)
 
-	resp, err := r.docker.ContainerCreate(r.ctx, &container.Config{
-		Image: img,
-		Cmd:   []string{"-export"},
-		Tty:   true,
-	}, nil, nil, nil, "")
-	if err != nil {
-		return err
-	}
-
-	defer func() {
-		if err := r.docker.ContainerRemove(r.ctx, resp.ID, container.RemoveOptions{}); err != nil {
-			log.Error("Error removing component container: %v", err)
-		}
-	}()
-
-	if err := r.docker.ContainerStart(r.ctx, resp.ID, container.StartOptions{}); err != nil {
-		return err
-	}
-
-	statusCh, errCh := r.docker.ContainerWait(r.ctx, resp.ID, container.WaitConditionNotRunning)
-	select {
-	case err := <-errCh:
-		if err != nil {
-			return err
-		}
-	case <-statusCh:
-	}
-
-	out, err := r.docker.ContainerLogs(r.ctx, resp.ID, container.LogsOptions{ShowStdout: true})
-	if err != nil {
-		return err
-	}
-	b, err := io.ReadAll(out)
+	b, err := r.builder.ExportCompDef(r.ctx, img)
 	if err != nil {
 		return err
 	}
@@ -277,17 +405,35 @@ func (r *repo) extractCompDef(compName string, comp *api.ComponentDefinition) er
 	return nil
 }
 
-func (r *repo) waitForReady(p *v1alpha1.Platform, spec *v1alpha1.AppDeploymentSpec) {
+// waitForReady waits up to the 'wait' flag for the components of the Platform
+// and AppDeployment to be ready and the AppDeployment to be available. If ve is
+// provided it also waits for its Release to be activated.
+func (r *repo) waitForReady(p *v1alpha1.Platform, appDep *v1alpha1.AppDeployment, ve *v1alpha1.VirtualEnvironment) error {
 	if r.cfg.Flags.DryRun {
-		return
+		return nil
 	}
 	if r.cfg.Flags.WaitTime <= 0 {
 		// Add small delay to allow resource status updates.
 		time.Sleep(time.Second)
 		log.InfoNewline()
-		return
+		return nil
 	}
 
-	r.k8s.WaitPlatformReady(r.cfg.Flags.WaitTime, p, spec)
+	ctx, cancel := context.WithTimeout(r.ctx, r.cfg.Flags.WaitTime)
+	defer cancel()
+
+	if err := r.k8s.WaitPlatformReady(ctx, p, &appDep.Spec); err != nil {
+		return err
+	}
+	if err := r.k8s.WaitAppDeploymentReady(ctx, appDep); err != nil {
+		return err
+	}
+	if ve != nil {
+		if err := r.k8s.WaitVirtualEnvReady(ctx, ve, appDep.Spec.AppName, appDep.Name); err != nil {
+			return err
+		}
+	}
 	log.InfoNewline()
+
+	return nil
 }
@@ -0,0 +1,276 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package repo
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/moby/patternmatcher"
+	"github.com/xigxog/fox/internal/log"
+	"github.com/xigxog/fox/internal/proxy"
+	foxutils "github.com/xigxog/fox/internal/utils"
+	"github.com/xigxog/kubefox/utils"
+)
+
+const (
+	// devDebounce is how long to wait after the last change before starting a
+	// rebuild, so saving several files results in a single rebuild.
+	devDebounce = 500 * time.Millisecond
+)
+
+// devState tracks what dev has built and deployed between cycles.
+type devState struct {
+	// built contains the hash of the last successful build of each component
+	// dir.
+	built map[string]string
+	// deployed contains the hash of each component dir in the last successful
+	// deployment.
+	deployed map[string]string
+	// redeploy is set if files outside of the component dirs, such as
+	// app.yaml, changed since the last successful deployment.
+	redeploy bool
+}
+
+// Dev builds and deploys the app from the working directory, including
+// uncommitted changes as with the 'dirty' flag, and then watches the repo for
+// changes. Components whose hash changed are rebuilt, loaded into kind and the
+// AppDeployment is updated, it is also updated if other files such as app.yaml
+// change. If proxyPort is greater than zero a proxy is started after the first
+// deployment. Dev blocks until interrupted.
+func (r *repo) Dev(proxyPort int) error {
+	r.cfg.Flags.Dirty = true
+	r.cfg.Flags.PushImage = true
+	if r.cfg.Flags.Kind == "" {
+		r.cfg.Flags.Kind = r.cfg.Kind.ClusterName
+	}
+	if r.cfg.Flags.AppDeployment == "" {
+		r.cfg.Flags.AppDeployment = utils.CleanName(fmt.Sprintf("%s-dev-%s", r.app.Name, devUser()))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	ignore, err := readIgnorePatterns(os.DirFS(r.cfg.RepoPath))
+	if err != nil {
+		return fmt.Errorf("error reading .dockerignore: %w", err)
+	}
+	pm, err := patternmatcher.New(ignore)
+	if err != nil {
+		return fmt.Errorf("error reading .dockerignore: %w", err)
+	}
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("error creating file watcher: %w", err)
+	}
+	defer w.Close()
+
+	if err := r.watchDir(w, pm, r.cfg.RepoPath); err != nil {
+		return fmt.Errorf("error watching repo '%s': %w", r.cfg.RepoPath, err)
+	}
+
+	state := &devState{built: map[string]string{}, deployed: map[string]string{}}
+	r.devCycle(ctx, state)
+
+	if proxyPort > 0 {
+		go func() {
+			if err := proxy.Start(proxyPort, r.cfg); err != nil {
+				log.Error("%v", err)
+			}
+		}()
+	}
+
+	log.Printf("Watching '%s' for changes, press ctrl-c to exit.\n", r.cfg.RepoPath)
+
+	timer := time.NewTimer(devDebounce)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case ev, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if r.isIgnored(pm, ev.Name) {
+				continue
+			}
+			if ev.Has(fsnotify.Create) {
+				if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+					if err := r.watchDir(w, pm, ev.Name); err != nil {
+						log.Error("Error watching dir '%s': %v", ev.Name, err)
+					}
+				}
+			}
+			if !r.isComponentPath(ev.Name) {
+				state.redeploy = true
+			}
+			log.Verbose("Detected change '%s'", ev)
+			timer.Reset(devDebounce)
+
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			log.Error("Error watching for changes: %v", err)
+
+		case <-timer.C:
+			r.devCycle(ctx, state)
+		}
+	}
+}
+
+// devCycle rebuilds components whose hash differs from their last successful
+// build and updates the AppDeployment if any component or other file changed
+// since the last successful deployment. A status line is printed for each
+// component. Failed builds and deployments are retried on the next cycle.
+func (r *repo) devCycle(ctx context.Context, state *devState) {
+	// Each cycle gets a fresh timeout as dev is long-lived.
+	var cancel context.CancelFunc
+	r.ctx, cancel = context.WithTimeout(ctx, r.cfg.Flags.Timeout)
+	defer cancel()
+	// Files changed since the last cycle.
+	r.resetDirtyCommit()
+
+	compsDir, err := os.ReadDir(r.ComponentsDir())
+	if err != nil {
+		log.Error("Error listing components dir '%s': %v", r.ComponentsDir(), err)
+		return
+	}
+
+	log.Printf("\n[%s] Checking components for changes...\n", time.Now().Format(time.TimeOnly))
+
+	var comps, changed []*compBuild
+	status := map[string]string{}
+	for _, compDir := range compsDir {
+		if !compDir.IsDir() {
+			continue
+		}
+		comp, err := r.compBuild(compDir.Name())
+		if err != nil {
+			printDevStatus(utils.CleanName(compDir.Name()), "failed", err.Error())
+			continue
+		}
+		comps = append(comps, comp)
+		if state.built[comp.dirName] != comp.hash {
+			changed = append(changed, comp)
+		} else {
+			status[comp.name] = "unchanged"
+		}
+	}
+
+	if len(changed) > 0 {
+		meta, err := r.buildMeta()
+		if err != nil {
+			log.Error("%v", err)
+			return
+		}
+		errs := r.buildAll(meta, changed)
+		for i, comp := range changed {
+			if errs[i] != nil {
+				printDevStatus(comp.name, "failed", errs[i].Error())
+				continue
+			}
+			state.built[comp.dirName] = comp.hash
+			status[comp.name] = "rebuilt"
+		}
+	}
+	for _, comp := range comps {
+		if s, found := status[comp.name]; found {
+			printDevStatus(comp.name, s, comp.image)
+		}
+	}
+
+	if len(status) != len(comps) || len(comps) == 0 {
+		log.Printf("Skipping deployment until all components build successfully.\n")
+		return
+	}
+	deploy := state.redeploy || len(comps) != len(state.deployed)
+	for _, comp := range comps {
+		deploy = deploy || state.deployed[comp.dirName] != comp.hash
+	}
+	if !deploy {
+		return
+	}
+
+	appDep, err := r.Deploy(true)
+	if err != nil {
+		log.Error("Error updating AppDeployment: %v", err)
+		return
+	}
+	state.deployed = map[string]string{}
+	for _, comp := range comps {
+		state.deployed[comp.dirName] = comp.hash
+	}
+	state.redeploy = false
+	log.Printf("AppDeployment '%s' updated.\n", appDep.Name)
+}
+
+// watchDir adds dir and all dirs below it to the watcher. The .git dir and
+// dirs matching the ignore patterns are skipped.
+func (r *repo) watchDir(w *fsnotify.Watcher, ignore *patternmatcher.PatternMatcher, dir string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if d.Name() == ".git" {
+			return fs.SkipDir
+		}
+		if r.isIgnored(ignore, p) && !ignore.Exclusions() {
+			return fs.SkipDir
+		}
+
+		return w.Add(p)
+	})
+}
+
+func (r *repo) isIgnored(ignore *patternmatcher.PatternMatcher, p string) bool {
+	subpath := foxutils.Subpath(p, r.cfg.RepoPath)
+	if subpath == "" {
+		return false
+	}
+	if subpath == ".git" || strings.HasPrefix(subpath, ".git"+string(filepath.Separator)) {
+		return true
+	}
+	skip, _ := ignore.MatchesOrParentMatches(subpath)
+
+	return skip
+}
+
+// isComponentPath returns true if p is in the components dir.
+func (r *repo) isComponentPath(p string) bool {
+	return strings.HasPrefix(p, r.ComponentsDir()+string(filepath.Separator))
+}
+
+func printDevStatus(comp, status, msg string) {
+	log.Printf("  %-20s %-10s %s\n", comp, status, msg)
+}
+
+// devUser returns the name of the current user, used to name personal
+// AppDeployments.
+func devUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+
+	return "local"
+}
@@ -0,0 +1,351 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package repo
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/xigxog/fox/internal/log"
+	"github.com/xigxog/kubefox/api/kubernetes/v1alpha1"
+	"github.com/xigxog/kubefox/k8s"
+	"sigs.k8s.io/yaml"
+)
+
+type DiffOp string
+
+const (
+	DiffOpAdd    DiffOp = "add"
+	DiffOpRemove DiffOp = "remove"
+	DiffOpChange DiffOp = "change"
+)
+
+// AppDeploymentDiff contains the changes between the live AppDeployment and
+// the one generated from the repo.
+type AppDeploymentDiff struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	// Exists is false if the AppDeployment is not on the cluster, in which
+	// case every field of the generated AppDeployment is an addition.
+	Exists  bool         `json:"exists"`
+	Changes []DiffChange `json:"changes"`
+}
+
+// DiffChange is a change to a single field. Path is the location of the field
+// in the AppDeployment, for example 'spec.components.frontend.hash'. Entries
+// of lists with an 'id' field are identified by their id, other list entries
+// by their index.
+type DiffChange struct {
+	Path      string `json:"path"`
+	Op        DiffOp `json:"op"`
+	Live      any    `json:"live,omitempty"`
+	Generated any    `json:"generated,omitempty"`
+}
+
+// Diff generates the AppDeployment for the currently checked out commit and
+// compares it with the AppDeployment of the same name on the cluster. Nothing
+// is built or applied. Component definitions can only be read from images
+// that exist, if a component's image is missing only its hash and image are
+// compared.
+func (r *repo) Diff() (*AppDeploymentDiff, error) {
+	name, err := r.appDepName()
+	if err != nil {
+		return nil, err
+	}
+	appDep, err := r.buildAppDep()
+	if err != nil {
+		return nil, err
+	}
+	appDep.Name = name
+
+	p, err := r.k8s.GetPlatform(r.ctx)
+	if err != nil {
+		return nil, err
+	}
+	appDep.Namespace = p.Namespace
+	appDep.Spec.ImagePullSecretName = r.imagePullSecretName(appDep.Spec.AppName)
+
+	live, err := r.getLiveAppDep(appDep)
+	if err != nil {
+		return nil, err
+	}
+
+	for compName, comp := range appDep.Spec.Components {
+		img := r.GetCompImage(compName, comp.Hash)
+		if found, _ := r.DoesImageExists(img, false); found {
+			if err := r.extractCompDef(compName, comp); err != nil {
+				return nil, fmt.Errorf("error getting component '%s' definition: %w", compName, err)
+			}
+			continue
+		}
+
+		log.Warn("Component image '%s' does not exist, its definition cannot be compared.", img)
+		if live != nil && live.Spec.Components[compName] != nil {
+			liveComp := live.Spec.Components[compName].DeepCopy()
+			liveComp.Hash, liveComp.Image = comp.Hash, comp.Image
+			appDep.Spec.Components[compName] = liveComp
+		}
+	}
+
+	return diffAppDep(live, appDep)
+}
+
+// confirmDiff shows the changes appDep makes to the live AppDeployment and
+// asks the user to confirm them.
+func (r *repo) confirmDiff(appDep *v1alpha1.AppDeployment) error {
+	live, err := r.getLiveAppDep(appDep)
+	if err != nil {
+		return err
+	}
+	d, err := diffAppDep(live, appDep)
+	if err != nil {
+		return err
+	}
+	if !d.HasChanges() {
+		log.Info("AppDeployment '%s' is unchanged.", appDep.Name)
+		return nil
+	}
+
+	log.Eprint(d.Unified())
+	if !r.cfg.Prompter().YesNo("Apply changes to AppDeployment?", false) {
+		return ErrDeployAborted
+	}
+
+	return nil
+}
+
+// getLiveAppDep returns the AppDeployment on the cluster with the same name as
+// appDep, or nil if it does not exist.
+func (r *repo) getLiveAppDep(appDep *v1alpha1.AppDeployment) (*v1alpha1.AppDeployment, error) {
+	live := &v1alpha1.AppDeployment{}
+	if err := r.k8s.Get(r.ctx, k8s.Key(appDep.Namespace, appDep.Name), live); k8s.IgnoreNotFound(err) != nil {
+		return nil, fmt.Errorf("error getting AppDeployment: %w", err)
+	} else if err != nil {
+		return nil, nil
+	}
+
+	return live, nil
+}
+
+// diffAppDep compares the spec and details of the live and generated
+// AppDeployments. Metadata and status are managed by the cluster and ignored.
+// The image of each component is included so changes to the container
+// registry are also shown.
+func diffAppDep(live, gen *v1alpha1.AppDeployment) (*AppDeploymentDiff, error) {
+	d := &AppDeploymentDiff{
+		Name:      gen.Name,
+		Namespace: gen.Namespace,
+		Exists:    live != nil,
+		Changes:   []DiffChange{},
+	}
+
+	genObj, err := diffObject(gen)
+	if err != nil {
+		return nil, err
+	}
+	var liveObj any = map[string]any{"spec": map[string]any{}, "details": map[string]any{}}
+	if live != nil {
+		if liveObj, err = diffObject(live); err != nil {
+			return nil, err
+		}
+	}
+	d.Changes = diffValues("", liveObj, genObj, d.Changes)
+
+	return d, nil
+}
+
+func diffObject(appDep *v1alpha1.AppDeployment) (any, error) {
+	spec := appDep.Spec.DeepCopy()
+	for name, comp := range spec.Components {
+		if comp.Image == "" {
+			comp.Image = fmt.Sprintf("%s/%s/%s:%s", spec.ContainerRegistry, spec.AppName, name, comp.Hash)
+		}
+	}
+
+	b, err := json.Marshal(map[string]any{
+		"spec":    spec,
+		"details": appDep.Details,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling AppDeployment: %w", err)
+	}
+	var obj any
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return nil, fmt.Errorf("error unmarshaling AppDeployment: %w", err)
+	}
+
+	return obj, nil
+}
+
+// diffValues appends the changes between live and gen, which are values
+// produced by unmarshaling JSON, to changes.
+func diffValues(path string, live, gen any, changes []DiffChange) []DiffChange {
+	_, lmap := live.(map[string]any)
+	_, gmap := gen.(map[string]any)
+	switch {
+	case reflect.DeepEqual(live, gen), isEmpty(live) && isEmpty(gen):
+		return changes
+	case lmap && gmap:
+		// Compare fields of objects, even if one is empty.
+	case isEmpty(live):
+		return append(changes, DiffChange{Path: path, Op: DiffOpAdd, Generated: gen})
+	case isEmpty(gen):
+		return append(changes, DiffChange{Path: path, Op: DiffOpRemove, Live: live})
+	}
+
+	switch l := live.(type) {
+	case map[string]any:
+		if g, ok := gen.(map[string]any); ok {
+			keys := map[string]bool{}
+			for k := range l {
+				keys[k] = true
+			}
+			for k := range g {
+				keys[k] = true
+			}
+			for _, k := range sortedKeys(keys) {
+				changes = diffValues(joinPath(path, k), l[k], g[k], changes)
+			}
+			return changes
+		}
+
+	case []any:
+		if g, ok := gen.([]any); ok {
+			keys, lm, gm := listEntries(l, g)
+			for _, k := range keys {
+				changes = diffValues(path+k, lm[k], gm[k], changes)
+			}
+			return changes
+		}
+	}
+
+	return append(changes, DiffChange{Path: path, Op: DiffOpChange, Live: live, Generated: gen})
+}
+
+// listEntries returns the keys and entries of the live and generated lists.
+// If every entry has an 'id' field entries are keyed by their id, otherwise by
+// their index. Keys are ordered as they appear in the lists.
+func listEntries(live, gen []any) ([]string, map[string]any, map[string]any) {
+	keys := []string{}
+	lm, lok := idEntries(live, &keys)
+	gm, gok := idEntries(gen, &keys)
+	if lok && gok {
+		return keys, lm, gm
+	}
+
+	keys = []string{}
+	lm, gm = map[string]any{}, map[string]any{}
+	for i := 0; i < max(len(live), len(gen)); i++ {
+		k := fmt.Sprintf("[%d]", i)
+		keys = append(keys, k)
+		if i < len(live) {
+			lm[k] = live[i]
+		}
+		if i < len(gen) {
+			gm[k] = gen[i]
+		}
+	}
+
+	return keys, lm, gm
+}
+
+func idEntries(list []any, keys *[]string) (map[string]any, bool) {
+	m := make(map[string]any, len(list))
+	for _, e := range list {
+		obj, ok := e.(map[string]any)
+		if !ok || obj["id"] == nil {
+			return nil, false
+		}
+		k := fmt.Sprintf("[id=%v]", obj["id"])
+		if !slices.Contains(*keys, k) {
+			*keys = append(*keys, k)
+		}
+		m[k] = e
+	}
+
+	return m, true
+}
+
+func isEmpty(v any) bool {
+	if v == nil {
+		return true
+	}
+	switch t := v.(type) {
+	case map[string]any:
+		return len(t) == 0
+	case []any:
+		return len(t) == 0
+	case string:
+		return t == ""
+	}
+
+	return false
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	if strings.ContainsAny(key, ".[]") {
+		return fmt.Sprintf("%s[%q]", path, key)
+	}
+	return path + "." + key
+}
+
+// HasChanges returns true if the generated AppDeployment differs from the live
+// one.
+func (d *AppDeploymentDiff) HasChanges() bool {
+	return len(d.Changes) > 0
+}
+
+// Unified returns the changes in a format similar to a unified diff. Each
+// changed field is a hunk whose header is the path of the field.
+func (d *AppDeploymentDiff) Unified() string {
+	var b strings.Builder
+
+	name := fmt.Sprintf("AppDeployment '%s/%s'", d.Namespace, d.Name)
+	if d.Exists {
+		fmt.Fprintf(&b, "--- %s (live)\n", name)
+	} else {
+		fmt.Fprintf(&b, "--- /dev/null\n")
+	}
+	fmt.Fprintf(&b, "+++ %s (generated)\n", name)
+
+	for _, c := range d.Changes {
+		fmt.Fprintf(&b, "@@ %s @@\n", c.Path)
+		if c.Op != DiffOpAdd {
+			writeDiffLines(&b, "-", c.Live)
+		}
+		if c.Op != DiffOpRemove {
+			writeDiffLines(&b, "+", c.Generated)
+		}
+	}
+
+	return b.String()
+}
+
+func writeDiffLines(b *strings.Builder, prefix string, v any) {
+	var s string
+	switch t := v.(type) {
+	case string:
+		s = t
+	case map[string]any, []any:
+		out, _ := yaml.Marshal(t)
+		s = strings.TrimSuffix(string(out), "\n")
+	default:
+		s = fmt.Sprint(t)
+	}
+
+	for _, line := range strings.Split(s, "\n") {
+		fmt.Fprintf(b, "%s%s\n", prefix, line)
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package repo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/xigxog/fox/internal/log"
+)
+
+const (
+	// LabelDirty is set to 'true' on AppDeployments deployed from uncommitted
+	// changes. These AppDeployments cannot be released.
+	LabelDirty = "kubefox.xigxog.io/dirty"
+	// LabelDirtyBaseCommit is the commit the uncommitted changes are based on.
+	LabelDirtyBaseCommit = "kubefox.xigxog.io/dirty-base-commit"
+	// LabelDirtyDiffDigest is the digest of the uncommitted changes.
+	LabelDirtyDiffDigest = "kubefox.xigxog.io/dirty-diff-digest"
+
+	// dirtyCommitPrefix starts synthetic commits. It contains non-hex
+	// characters so a synthetic commit cannot be confused with a real one.
+	dirtyCommitPrefix = "dirty"
+	// KubeFox requires commits to be 40 lowercase alpha-numeric characters.
+	commitLen = 40
+)
+
+// DirtyCommit identifies the uncommitted changes in the working directory.
+type DirtyCommit struct {
+	// Base is the hash of the HEAD commit.
+	Base string
+	// Digest is the digest of the uncommitted changes.
+	Digest string
+	// ID is the synthetic commit generated from the base commit and digest.
+	ID string
+}
+
+// GetCommitID returns the commit recorded in images and AppDeployments. If
+// the working directory has uncommitted changes a synthetic commit is returned.
+func (r *repo) GetCommitID() (string, error) {
+	d, err := r.GetDirtyCommit()
+	if err != nil {
+		return "", err
+	}
+	if d != nil {
+		return d.ID, nil
+	}
+
+	c, err := r.GetCommit()
+	if err != nil {
+		return "", err
+	}
+
+	return c.Hash.String(), nil
+}
+
+// GetDirtyCommit returns the synthetic commit for uncommitted changes in the
+// working directory. Nil is returned if the 'dirty' flag is not set or there
+// are no uncommitted changes. The commit is generated once and cached until
+// resetDirtyCommit is called.
+func (r *repo) GetDirtyCommit() (*DirtyCommit, error) {
+	r.dirtyMutex.Lock()
+	defer r.dirtyMutex.Unlock()
+
+	if r.dirtyDone {
+		return r.dirty, nil
+	}
+	d, err := r.dirtyCommit()
+	if err != nil {
+		return nil, err
+	}
+	r.dirty, r.dirtyDone = d, true
+
+	return d, nil
+}
+
+// resetDirtyCommit clears the cached synthetic commit so it is generated again
+// from the current working directory.
+func (r *repo) resetDirtyCommit() {
+	r.dirtyMutex.Lock()
+	defer r.dirtyMutex.Unlock()
+
+	r.dirty, r.dirtyDone = nil, false
+}
+
+func (r *repo) dirtyCommit() (*DirtyCommit, error) {
+	if !r.cfg.Flags.Dirty {
+		return nil, nil
+	}
+	if clean, err := r.IsClean(); err != nil || clean {
+		return nil, err
+	}
+
+	c, err := r.GetCommit()
+	if err != nil {
+		return nil, err
+	}
+	base := c.Hash.String()
+	digest, err := r.diffDigest()
+	if err != nil {
+		return nil, fmt.Errorf("error generating digest of uncommitted changes: %w", err)
+	}
+
+	id := sha256.Sum256([]byte(base + digest))
+	return &DirtyCommit{
+		Base:   base,
+		Digest: digest,
+		ID:     dirtyCommitPrefix + hex.EncodeToString(id[:])[:commitLen-len(dirtyCommitPrefix)],
+	}, nil
+}
+
+// diffDigest generates a digest from the paths and contents of all files that
+// differ from the HEAD commit, including untracked files that are not ignored.
+func (r *repo) diffDigest() (string, error) {
+	w, err := r.gitRepo.Worktree()
+	if err != nil {
+		return "", err
+	}
+	status, err := w.Status()
+	if err != nil {
+		return "", err
+	}
+
+	paths := make([]string, 0, len(status))
+	for p, s := range status {
+		if s.Staging != git.Unmodified || s.Worktree != git.Unmodified {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	h := sha256.New()
+	for _, p := range paths {
+		sum, err := hashPath(filepath.Join(r.cfg.RepoPath, p))
+		if err != nil {
+			return "", err
+		}
+		log.Verbose("Uncommitted change '%s' (%s)", p, sum)
+		fmt.Fprintf(h, "%s\x00%s\n", p, sum)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:commitLen], nil
+}
+
+// hashPath returns the sha256 of the file's contents, or 'deleted' if the file
+// does not exist.
+func hashPath(p string) (string, error) {
+	f, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "deleted", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,256 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package repo
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/registry"
+	docker "github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/xigxog/fox/internal/config"
+	"github.com/xigxog/fox/internal/log"
+)
+
+const (
+	injectedDockerfile = "__Dockerfile"
+)
+
+type dockerBuilder struct {
+	cfg *config.Config
+	cli *docker.Client
+}
+
+type DockerfileTar struct {
+	dockerfile []byte
+	wrapped    io.ReadCloser
+	read       int
+}
+
+func newDockerBuilder(cfg *config.Config) (*dockerBuilder, error) {
+	cli, err := docker.NewClientWithOpts(docker.FromEnv, docker.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("error creating Docker client: %w", err)
+	}
+
+	return &dockerBuilder{
+		cfg: cfg,
+		cli: cli,
+	}, nil
+}
+
+func (b *dockerBuilder) Build(ctx context.Context, req *BuildRequest) error {
+	dfi, err := NewDFI(req.RepoPath, req.Dockerfile)
+	if err != nil {
+		return fmt.Errorf("error creating container tar: %w", err)
+	}
+
+	buildResp, err := b.cli.ImageBuild(ctx, dfi, types.ImageBuildOptions{
+		Dockerfile: injectedDockerfile,
+		NoCache:    req.NoCache,
+		Remove:     true,
+		Tags:       []string{req.Image},
+		Labels:     req.Labels,
+		BuildArgs:  req.BuildArgs,
+	})
+	if err != nil {
+		return err
+	}
+
+	return logResp(ctx, buildResp.Body)
+}
+
+func (b *dockerBuilder) IsImageLocal(ctx context.Context, img string) bool {
+	l, _ := b.cli.ImageList(ctx, types.ImageListOptions{
+		Filters: filters.NewArgs(filters.Arg("reference", img)),
+	})
+
+	return len(l) > 0
+}
+
+func (b *dockerBuilder) IsImageRemote(ctx context.Context, img string) (bool, error) {
+	di, err := b.cli.DistributionInspect(ctx, img, b.regAuth())
+	if err != nil {
+		log.FromContext(ctx).Verbose("%s", err)
+		return false, err
+	}
+	log.FromContext(ctx).Verbose("Digest: %s", di.Descriptor.Digest)
+
+	return true, nil
+}
+
+func (b *dockerBuilder) Pull(ctx context.Context, img string) error {
+	pullResp, err := b.cli.ImagePull(ctx, img, types.ImagePullOptions{
+		RegistryAuth: b.regAuth(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return logResp(ctx, pullResp)
+}
+
+func (b *dockerBuilder) Push(ctx context.Context, img string) error {
+	pushResp, err := b.cli.ImagePush(ctx, img, types.ImagePushOptions{
+		RegistryAuth: b.regAuth(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return logResp(ctx, pushResp)
+}
+
+func (b *dockerBuilder) LoadKind(ctx context.Context, img, cluster string) error {
+	_, err := runCmd(ctx, "kind", "load", "docker-image", "--name="+cluster, img)
+	return err
+}
+
+func (b *dockerBuilder) ExportCompDef(ctx context.Context, img string) ([]byte, error) {
+	resp, err := b.cli.ContainerCreate(ctx, &container.Config{
+		Image: img,
+		Cmd:   []string{"-export"},
+		Tty:   true,
+	}, nil, nil, nil, "")
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := b.cli.ContainerRemove(ctx, resp.ID, container.RemoveOptions{}); err != nil {
+			log.FromContext(ctx).Error("Error removing component container: %v", err)
+		}
+	}()
+
+	if err := b.cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		return nil, err
+	}
+
+	statusCh, errCh := b.cli.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			return nil, err
+		}
+	case <-statusCh:
+	}
+
+	out, err := b.cli.ContainerLogs(ctx, resp.ID, container.LogsOptions{ShowStdout: true})
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(out)
+}
+
+func (b *dockerBuilder) regAuth() string {
+	user, token := regCreds(b.cfg)
+	if user == "" {
+		user = "kubefox"
+	}
+	authCfg, _ := json.Marshal(registry.AuthConfig{
+		Username: user,
+		Password: token,
+	})
+
+	return base64.StdEncoding.EncodeToString(authCfg)
+}
+
+func logResp(ctx context.Context, resp io.ReadCloser) error {
+	defer resp.Close()
+
+	l := log.FromContext(ctx)
+	scanner := bufio.NewScanner(resp)
+	for scanner.Scan() {
+		m := make(map[string]any)
+		json.Unmarshal(scanner.Bytes(), &m)
+		logLine(l, m, "stream")
+		logLine(l, m, "status", "id")
+		if s, f := m["error"]; f {
+			return fmt.Errorf("%s", s)
+		}
+	}
+
+	return nil
+}
+
+func logLine(l *log.Prefixed, m map[string]any, keys ...string) {
+	var msg string
+	for _, k := range keys {
+		if s, f := m[k]; f {
+			if msg == "" {
+				msg = fmt.Sprintf("%s", s)
+			} else {
+				msg = fmt.Sprintf("%s %s", msg, s)
+			}
+
+		}
+	}
+	msg = strings.ReplaceAll(msg, "\n", "")
+	if strings.TrimSpace(msg) != "" {
+		l.Verbose("%s", msg)
+	}
+}
+
+func NewDFI(path string, df []byte) (*DockerfileTar, error) {
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     injectedDockerfile,
+		Size:     int64(len(df)),
+		Mode:     644,
+		ModTime:  time.Time{},
+	})
+	w.Write(df)
+	w.Flush()
+
+	ignore, err := readIgnorePatterns(os.DirFS(path))
+	if err != nil {
+		return nil, err
+	}
+	tar, err := archive.TarWithOptions(path, &archive.TarOptions{
+		ExcludePatterns: ignore,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &DockerfileTar{
+		wrapped:    tar,
+		dockerfile: buf.Bytes(),
+	}, nil
+}
+
+func (dfi *DockerfileTar) Read(p []byte) (n int, err error) {
+	if dfi.read < len(dfi.dockerfile) {
+		c := copy(p, dfi.dockerfile)
+		dfi.read = dfi.read + c
+		return c, nil
+	}
+
+	return dfi.wrapped.Read(p)
+}
+
+func (dfi *DockerfileTar) Close() error {
+	return dfi.wrapped.Close()
+}
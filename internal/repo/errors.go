@@ -0,0 +1,20 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package repo
+
+import "github.com/xigxog/fox/internal/errs"
+
+var (
+	ErrUncommittedChanges = errs.New(errs.TypeDirty, "uncommitted changes present, commit them or set the 'dirty' flag")
+	ErrMissingImages      = errs.New(errs.TypeMissingImage, "one or more component images are missing")
+	ErrBuildFailed        = errs.New(errs.TypeBuildFailed, "one or more components failed to build")
+	ErrDirtyRelease       = errs.New(errs.TypeDirty, "AppDeployments deployed from uncommitted changes cannot be released")
+	ErrDeployAborted      = errs.New(errs.TypeAborted, "aborted, AppDeployment not changed")
+	ErrReleaseProblems    = errs.New(errs.TypeValidation, "problems that would prevent Release activation exist")
+)
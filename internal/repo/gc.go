@@ -0,0 +1,193 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package repo
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/xigxog/fox/internal/errs"
+	"github.com/xigxog/fox/internal/log"
+	"github.com/xigxog/kubefox/api/kubernetes/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	GCActionKeep   = "keep"
+	GCActionDelete = "delete"
+)
+
+// GCResult is the outcome of garbage collection for an AppDeployment.
+type GCResult struct {
+	Name      string      `json:"name"`
+	Namespace string      `json:"namespace"`
+	Branch    string      `json:"branch,omitempty"`
+	Created   metav1.Time `json:"created"`
+	Action    string      `json:"action"`
+	Reason    string      `json:"reason"`
+}
+
+// Undeploy deletes the AppDeployment. AppDeployments that are part of the
+// active or pending Release of a VirtualEnvironment are not deleted unless the
+// 'force' flag is set.
+func (r *repo) Undeploy(appDepId string) (*v1alpha1.AppDeployment, error) {
+	p, err := r.k8s.GetPlatform(r.ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	appDep, err := r.findAppDep(r.ctx, p, appDepId)
+	if err != nil {
+		return nil, fmt.Errorf("error finding AppDeployment: %w", err)
+	}
+
+	veList, err := r.listVirtualEnvs(p)
+	if err != nil {
+		return nil, err
+	}
+	if ve := releasedBy(veList, appDep, false); ve != "" && !r.cfg.Flags.Force {
+		return nil, errs.New(errs.TypeValidation,
+			"AppDeployment '%s' is released by VirtualEnvironment '%s', use the 'force' flag to delete it anyway", appDep.Name, ve)
+	}
+
+	log.Info("Deleting AppDeployment '%s'.", appDep.Name)
+	if err := r.k8s.Delete(r.ctx, appDep); err != nil {
+		return nil, fmt.Errorf("error deleting AppDeployment: %w", err)
+	}
+
+	appDep.TypeMeta = metav1.TypeMeta{
+		APIVersion: v1alpha1.GroupVersion.Identifier(),
+		Kind:       "AppDeployment",
+	}
+
+	return appDep, nil
+}
+
+// PlanGC determines which AppDeployments of the app should be deleted.
+// AppDeployments referenced by a Release of a VirtualEnvironment, including
+// its history, are always kept. Of the rest, the newest 'keep' AppDeployments
+// of each branch are kept and the others are deleted if older than
+// 'older-than'. A result is returned for every AppDeployment, newest first.
+func (r *repo) PlanGC() ([]GCResult, error) {
+	p, err := r.k8s.GetPlatform(r.ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	appDepList := &v1alpha1.AppDeploymentList{}
+	if err := r.k8s.List(r.ctx, appDepList, client.InNamespace(p.Namespace)); err != nil {
+		return nil, fmt.Errorf("error listing AppDeployments: %w", err)
+	}
+	veList, err := r.listVirtualEnvs(p)
+	if err != nil {
+		return nil, err
+	}
+
+	appDeps := []*v1alpha1.AppDeployment{}
+	for i, appDep := range appDepList.Items {
+		if appDep.Spec.AppName == r.app.Name {
+			appDeps = append(appDeps, &appDepList.Items[i])
+		}
+	}
+	// Newest first so position within branch can be used for retention.
+	sort.SliceStable(appDeps, func(i, j int) bool {
+		return appDeps[j].CreationTimestamp.Before(&appDeps[i].CreationTimestamp)
+	})
+
+	now := time.Now()
+	perBranch := map[string]int{}
+	results := make([]GCResult, 0, len(appDeps))
+	for _, appDep := range appDeps {
+		res := GCResult{
+			Name:      appDep.Name,
+			Namespace: appDep.Namespace,
+			Branch:    appDep.Spec.Branch,
+			Created:   appDep.CreationTimestamp,
+			Action:    GCActionKeep,
+		}
+
+		age := now.Sub(appDep.CreationTimestamp.Time)
+		switch ve := releasedBy(veList, appDep, true); {
+		case ve != "":
+			res.Reason = fmt.Sprintf("released by VirtualEnvironment '%s'", ve)
+		case perBranch[appDep.Spec.Branch] < r.cfg.Flags.Keep:
+			perBranch[appDep.Spec.Branch]++
+			res.Reason = fmt.Sprintf("one of newest %d of branch", r.cfg.Flags.Keep)
+		case age < r.cfg.Flags.OlderThan:
+			res.Reason = fmt.Sprintf("newer than %s", r.cfg.Flags.OlderThan)
+		default:
+			res.Action = GCActionDelete
+			res.Reason = fmt.Sprintf("created %s ago", age.Round(time.Second))
+		}
+		results = append(results, res)
+	}
+
+	return results, nil
+}
+
+// GC deletes the AppDeployments planned for deletion by PlanGC.
+func (r *repo) GC(results []GCResult) error {
+	var total, failed int
+	for _, res := range results {
+		if res.Action != GCActionDelete {
+			continue
+		}
+		total++
+
+		log.Info("Deleting AppDeployment '%s'.", res.Name)
+		appDep := &v1alpha1.AppDeployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: res.Namespace, Name: res.Name},
+		}
+		if err := r.k8s.Delete(r.ctx, appDep); client.IgnoreNotFound(err) != nil {
+			log.Error("Error deleting AppDeployment '%s': %v", res.Name, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return errs.New(errs.TypeGeneral, "%d of %d AppDeployments could not be deleted", failed, total)
+	}
+
+	return nil
+}
+
+func (r *repo) listVirtualEnvs(p *v1alpha1.Platform) ([]v1alpha1.VirtualEnvironment, error) {
+	veList := &v1alpha1.VirtualEnvironmentList{}
+	if err := r.k8s.List(r.ctx, veList, client.InNamespace(p.Namespace)); err != nil {
+		return nil, fmt.Errorf("error listing VirtualEnvironments: %w", err)
+	}
+
+	return veList.Items, nil
+}
+
+// releasedBy returns the name of the first VirtualEnvironment whose requested,
+// active or pending Release includes the AppDeployment. If history is true
+// Releases in the VirtualEnvironment's history are also checked.
+func releasedBy(veList []v1alpha1.VirtualEnvironment, appDep *v1alpha1.AppDeployment, history bool) string {
+	app, name := appDep.Spec.AppName, appDep.Name
+	for _, ve := range veList {
+		if ve.Spec.Release != nil && ve.Spec.Release.Apps[app].AppDeployment == name {
+			return ve.Name
+		}
+		if releases(ve.Status.ActiveRelease, app, name) || releases(ve.Status.PendingRelease, app, name) {
+			return ve.Name
+		}
+		if !history {
+			continue
+		}
+		for i := range ve.Status.ReleaseHistory {
+			if releases(&ve.Status.ReleaseHistory[i], app, name) {
+				return ve.Name
+			}
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,304 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package repo
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// treeFS provides read-only access to the files of a Git tree object. Only
+// committed contents are visible, untracked and ignored files in the working
+// directory are not.
+type treeFS struct {
+	tree *object.Tree
+}
+
+var (
+	_ fs.ReadDirFS = &treeFS{}
+	_ fs.StatFS    = &treeFS{}
+)
+
+func newTreeFS(tree *object.Tree) *treeFS {
+	return &treeFS{tree: tree}
+}
+
+func (t *treeFS) Open(name string) (fs.File, error) {
+	info, err := t.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	switch info.mode {
+	case filemode.Dir:
+		entries, err := t.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		return &treeDir{info: info, entries: entries}, nil
+
+	case filemode.Submodule:
+		// Contents of submodules are not part of the tree, use the hash of
+		// the commit the submodule points to instead.
+		return &treeFile{info: info, r: io.NopCloser(strings.NewReader(info.entry.Hash.String()))}, nil
+
+	default:
+		f, err := t.tree.TreeEntryFile(info.entry)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		r, err := f.Reader()
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &treeFile{info: info, r: r}, nil
+	}
+}
+
+func (t *treeFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	dir := t.tree
+	if name != "." {
+		var err error
+		if dir, err = t.tree.Tree(name); err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: treeErr(err)}
+		}
+	}
+
+	entries := make([]fs.DirEntry, 0, len(dir.Entries))
+	for i := range dir.Entries {
+		e := &dir.Entries[i]
+		info := &treeFileInfo{tree: dir, entry: e, mode: e.Mode}
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	return entries, nil
+}
+
+func (t *treeFS) Stat(name string) (fs.FileInfo, error) {
+	return t.stat("stat", name)
+}
+
+func (t *treeFS) stat(op, name string) (*treeFileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &treeFileInfo{tree: t.tree, entry: &object.TreeEntry{Name: "."}, mode: filemode.Dir}, nil
+	}
+
+	e, err := t.tree.FindEntry(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: treeErr(err)}
+	}
+
+	return &treeFileInfo{tree: t.tree, entry: e, mode: e.Mode}, nil
+}
+
+// treeErr converts go-git not found errors to fs.ErrNotExist.
+func treeErr(err error) error {
+	if errors.Is(err, object.ErrEntryNotFound) ||
+		errors.Is(err, object.ErrDirectoryNotFound) ||
+		errors.Is(err, object.ErrFileNotFound) {
+		return fs.ErrNotExist
+	}
+
+	return err
+}
+
+type treeFileInfo struct {
+	tree  *object.Tree
+	entry *object.TreeEntry
+	mode  filemode.FileMode
+}
+
+func (i *treeFileInfo) Name() string       { return i.entry.Name }
+func (i *treeFileInfo) ModTime() time.Time { return time.Time{} }
+func (i *treeFileInfo) IsDir() bool        { return i.mode == filemode.Dir }
+func (i *treeFileInfo) Sys() any           { return i.entry }
+
+func (i *treeFileInfo) Size() int64 {
+	if !i.mode.IsFile() {
+		return 0
+	}
+	f, err := i.tree.TreeEntryFile(i.entry)
+	if err != nil {
+		return 0
+	}
+
+	return f.Size
+}
+
+func (i *treeFileInfo) Mode() fs.FileMode {
+	switch i.mode {
+	case filemode.Dir:
+		return fs.ModeDir | 0755
+	case filemode.Executable:
+		return 0755
+	case filemode.Symlink:
+		return fs.ModeSymlink | 0777
+	case filemode.Submodule:
+		return fs.ModeIrregular | 0644
+	default:
+		return 0644
+	}
+}
+
+type treeFile struct {
+	info *treeFileInfo
+	r    io.ReadCloser
+}
+
+func (f *treeFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *treeFile) Read(b []byte) (int, error) { return f.r.Read(b) }
+func (f *treeFile) Close() error               { return f.r.Close() }
+
+type treeDir struct {
+	info    *treeFileInfo
+	entries []fs.DirEntry
+}
+
+func (d *treeDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *treeDir) Close() error               { return nil }
+
+func (d *treeDir) Read(b []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: fs.ErrInvalid}
+}
+
+func (d *treeDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+
+	return entries, nil
+}
+
+// worktreeFS provides read-only access to the files of the working directory
+// that are part of the Git repo. Untracked files matching .gitignore patterns
+// and the .git dir are hidden, so its contents match the Git tree that would be
+// committed if all changes were added.
+type worktreeFS struct {
+	fsys    fs.FS
+	ignore  gitignore.Matcher
+	tracked map[string]bool
+}
+
+var (
+	_ fs.ReadDirFS = &worktreeFS{}
+	_ fs.StatFS    = &worktreeFS{}
+)
+
+func newWorktreeFS(gitRepo *git.Repository) (*worktreeFS, error) {
+	w, err := gitRepo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	patterns, err := gitignore.ReadPatterns(w.Filesystem, nil)
+	if err != nil {
+		return nil, err
+	}
+	patterns = append(patterns, w.Excludes...)
+
+	idx, err := gitRepo.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+	// Tracked files are never ignored, even if they match a pattern.
+	tracked := map[string]bool{}
+	for _, e := range idx.Entries {
+		for p := e.Name; p != "."; p = path.Dir(p) {
+			tracked[p] = true
+		}
+	}
+
+	return &worktreeFS{
+		fsys:    os.DirFS(w.Filesystem.Root()),
+		ignore:  gitignore.NewMatcher(patterns),
+		tracked: tracked,
+	}, nil
+}
+
+func (w *worktreeFS) Open(name string) (fs.File, error) {
+	if _, err := w.Stat(name); err != nil {
+		return nil, err
+	}
+
+	return w.fsys.Open(name)
+}
+
+func (w *worktreeFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if _, err := w.Stat(name); err != nil {
+		return nil, err
+	}
+	entries, err := fs.ReadDir(w.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	visible := make([]fs.DirEntry, 0, len(entries))
+	for _, e := range entries {
+		if !w.hidden(path.Join(name, e.Name()), e.IsDir()) {
+			visible = append(visible, e)
+		}
+	}
+
+	return visible, nil
+}
+
+func (w *worktreeFS) Stat(name string) (fs.FileInfo, error) {
+	info, err := fs.Stat(w.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	if w.hidden(name, info.IsDir()) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return info, nil
+}
+
+func (w *worktreeFS) hidden(name string, isDir bool) bool {
+	switch {
+	case name == ".":
+		return false
+	case name == ".git" || strings.HasPrefix(name, ".git/"):
+		return true
+	case w.tracked[name]:
+		return false
+	default:
+		return w.ignore.Match(strings.Split(name, "/"), isDir)
+	}
+}
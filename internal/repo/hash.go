@@ -0,0 +1,222 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package repo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/moby/patternmatcher"
+	"github.com/moby/patternmatcher/ignorefile"
+	"github.com/xigxog/fox/internal/log"
+	foxutils "github.com/xigxog/fox/internal/utils"
+)
+
+const (
+	// KubeFox requires component hashes to be 32 lowercase hex characters.
+	compHashLen = 32
+)
+
+var (
+	// moduleFiles are Go module files that are inputs to the build of every
+	// component that they are found above.
+	moduleFiles = []string{"go.mod", "go.sum", "go.work", "go.work.sum"}
+)
+
+// compHash generates the component's hash from the Git tree of the HEAD
+// commit. Only committed files are used so the hash is the same on every
+// machine for the same commit.
+func (r *repo) compHash(compDirName string) (string, error) {
+	fsys, err := r.hashFS()
+	if err != nil {
+		return "", err
+	}
+
+	inputs, err := r.compHashInputs(fsys, compDirName)
+	if err != nil {
+		return "", err
+	}
+	ignore, err := readIgnorePatterns(fsys)
+	if err != nil {
+		return "", err
+	}
+	pm, err := patternmatcher.New(ignore)
+	if err != nil {
+		return "", err
+	}
+
+	return hashInputs(fsys, inputs, pm)
+}
+
+// hashFS returns the filesystem component hashes are generated from. This is
+// the Git tree of the HEAD commit, or the working directory if the 'dirty'
+// flag is set.
+func (r *repo) hashFS() (fs.FS, error) {
+	if r.cfg.Flags.Dirty {
+		return newWorktreeFS(r.gitRepo)
+	}
+
+	c, err := r.GetCommit()
+	if err != nil {
+		return nil, err
+	}
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("error reading Git tree: %w", err)
+	}
+
+	return newTreeFS(tree), nil
+}
+
+// compHashInputs returns the paths, relative to the root of the repo, whose
+// contents are used to generate the component's hash. This includes the
+// component directory, Go module files found between the component directory
+// and the root of the repo, and any extra inputs declared for the component in
+// the app definition. Paths are checked for existence in fsys.
+func (r *repo) compHashInputs(fsys fs.FS, compDirName string) ([]string, error) {
+	compDir := filepath.ToSlash(r.ComponentRepoSubpath(compDirName))
+	inputs := []string{compDir}
+
+	for dir := path.Dir(compDir); ; dir = path.Dir(dir) {
+		for _, f := range moduleFiles {
+			p := path.Join(dir, f)
+			if _, err := fs.Stat(fsys, p); err == nil {
+				inputs = append(inputs, p)
+			}
+		}
+		if dir == "." {
+			break
+		}
+	}
+
+	if comp, found := r.app.Components[compDirName]; found {
+		for _, in := range comp.Inputs {
+			abs := filepath.Join(r.cfg.AppPath, in)
+			if abs != r.cfg.RepoPath && !strings.HasPrefix(abs, r.cfg.RepoPath+string(filepath.Separator)) {
+				return nil, fmt.Errorf("input '%s' of component '%s' is not part of the Git repo", in, compDirName)
+			}
+
+			p := filepath.ToSlash(foxutils.Subpath(abs, r.cfg.RepoPath))
+			if p == "" {
+				p = "."
+			}
+			if _, err := fs.Stat(fsys, p); err != nil {
+				return nil, fmt.Errorf("input '%s' of component '%s' is invalid: %w", in, compDirName, err)
+			}
+			inputs = append(inputs, p)
+		}
+	}
+
+	return inputs, nil
+}
+
+// hashInputs generates a hash from the paths and contents of all files found
+// at or below the inputs. Files matching the ignore patterns are skipped. The
+// hash does not depend on the order of inputs or the order files are found.
+func hashInputs(fsys fs.FS, inputs []string, ignore *patternmatcher.PatternMatcher) (string, error) {
+	files := map[string]string{}
+	for _, in := range inputs {
+		err := fs.WalkDir(fsys, in, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if p != "." {
+				if skip, err := ignore.MatchesOrParentMatches(filepath.FromSlash(p)); err != nil {
+					return err
+				} else if skip {
+					// Directory can only be skipped if there are no exclusions
+					// that might match a file within it.
+					if d.IsDir() && !ignore.Exclusions() {
+						return fs.SkipDir
+					}
+					return nil
+				}
+			}
+			if d.IsDir() {
+				return nil
+			}
+			if _, found := files[p]; found {
+				return nil
+			}
+
+			sum, err := hashFile(fsys, p, d)
+			if err != nil {
+				return err
+			}
+			files[p] = sum
+
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	h := sha256.New()
+	for _, p := range paths {
+		log.Verbose("Hashing file '%s' (%s)", p, files[p])
+		fmt.Fprintf(h, "%s\x00%s\n", p, files[p])
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:compHashLen], nil
+}
+
+func hashFile(fsys fs.FS, p string, d fs.DirEntry) (string, error) {
+	h := sha256.New()
+	if d.Type()&fs.ModeSymlink != 0 {
+		// Symlinks to directories are not followed, only their path is
+		// included in the hash.
+		if info, err := fs.Stat(fsys, p); err != nil {
+			return "", err
+		} else if info.IsDir() {
+			return hex.EncodeToString(h.Sum(nil)), nil
+		}
+	}
+
+	f, err := fsys.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// readIgnorePatterns reads the patterns from the .dockerignore file at the
+// root of fsys. If the file does not exist the '.git' directory is ignored.
+func readIgnorePatterns(fsys fs.FS) ([]string, error) {
+	dif, err := fsys.Open(".dockerignore")
+	if errors.Is(err, fs.ErrNotExist) {
+		return []string{".git"}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer dif.Close()
+
+	return ignorefile.ReadAll(dif)
+}
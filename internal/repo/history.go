@@ -0,0 +1,241 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package repo
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/xigxog/fox/internal/errs"
+	"github.com/xigxog/fox/internal/log"
+	"github.com/xigxog/kubefox/api/kubernetes/v1alpha1"
+	"github.com/xigxog/kubefox/k8s"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// AnnotationReleaseHistory is the VirtualEnvironment annotation containing
+	// the Releases made by 🦊 Fox, encoded as a JSON list of ReleaseRecords,
+	// oldest first.
+	AnnotationReleaseHistory = "kubefox.xigxog.io/release-history"
+
+	// DefaultHistoryLimit is the number of records of each app kept if the
+	// 'history-limit' flag is not set.
+	DefaultHistoryLimit = 50
+	// Kubernetes limits the total size of an object's annotations to 256 KiB,
+	// the oldest records are dropped to keep the history within half of it.
+	maxReleaseHistorySize = 128 * 1024
+)
+
+// ReleaseRecord is a change to the Release of an app in a VirtualEnvironment.
+type ReleaseRecord struct {
+	App           string      `json:"app"`
+	AppDeployment string      `json:"appDeployment"`
+	Version       string      `json:"version,omitempty"`
+	Commit        string      `json:"commit,omitempty"`
+	User          string      `json:"user,omitempty"`
+	Time          metav1.Time `json:"time"`
+	Rollback      bool        `json:"rollback,omitempty"`
+}
+
+// ReleaseHistory returns the recorded Releases of the app to the
+// VirtualEnvironment, newest first.
+func (r *repo) ReleaseHistory() ([]ReleaseRecord, error) {
+	platform, err := r.k8s.GetPlatform(r.ctx)
+	if err != nil {
+		return nil, err
+	}
+	ve, err := r.getVirtualEnv(platform)
+	if err != nil {
+		return nil, err
+	}
+
+	records := releaseHistory(ve)
+	history := make([]ReleaseRecord, 0, len(records))
+	for i := len(records) - 1; i >= 0; i-- {
+		if records[i].App == r.app.Name {
+			history = append(history, records[i])
+		}
+	}
+
+	return history, nil
+}
+
+// Rollback releases the AppDeployment that was released to the
+// VirtualEnvironment before the current one. The previous AppDeployment is
+// found using the release history recorded by 🦊 Fox, falling back to the
+// VirtualEnvironment's Release history if none is recorded.
+func (r *repo) Rollback() (*v1alpha1.VirtualEnvironment, error) {
+	platform, err := r.k8s.GetPlatform(r.ctx)
+	if err != nil {
+		return nil, err
+	}
+	ve, err := r.getVirtualEnv(platform)
+	if err != nil {
+		return nil, err
+	}
+
+	var current string
+	if ve.Spec.Release != nil {
+		current = ve.Spec.Release.Apps[r.app.Name].AppDeployment
+	}
+	if current == "" {
+		return nil, errs.New(errs.TypeNotFound, "app '%s' is not released to VirtualEnvironment '%s'", r.app.Name, ve.Name)
+	}
+
+	var appDep *v1alpha1.AppDeployment
+	for _, name := range r.previousReleases(ve, current) {
+		a := &v1alpha1.AppDeployment{}
+		if err := r.k8s.Get(r.ctx, k8s.Key(platform.Namespace, name), a); k8s.IgnoreNotFound(err) != nil {
+			return nil, fmt.Errorf("error getting AppDeployment '%s': %w", name, err)
+		} else if err != nil {
+			log.Info("Previously released AppDeployment '%s' no longer exists, skipping.", name)
+			continue
+		}
+		appDep = a
+		break
+	}
+	if appDep == nil {
+		return nil, errs.New(errs.TypeNotFound, "no previous Release of app '%s' found for VirtualEnvironment '%s'", r.app.Name, ve.Name)
+	}
+
+	log.Info("Rolling back VirtualEnvironment '%s' from AppDeployment '%s' to '%s'.", ve.Name, current, appDep.Name)
+	if err := r.validateRelease(appDep, ve); err != nil {
+		return nil, err
+	}
+
+	return r.updateRelease(platform, ve, appDep, true)
+}
+
+// previousReleases returns the names of the AppDeployments of the app that
+// were released to the VirtualEnvironment before current, newest first.
+func (r *repo) previousReleases(ve *v1alpha1.VirtualEnvironment, current string) []string {
+	names := []string{}
+	add := func(name string) {
+		if name == "" || name == current {
+			return
+		}
+		for _, n := range names {
+			if n == name {
+				return
+			}
+		}
+		names = append(names, name)
+	}
+
+	records := releaseHistory(ve)
+	for i := len(records) - 1; i >= 0; i-- {
+		if records[i].App == r.app.Name {
+			add(records[i].AppDeployment)
+		}
+	}
+	if len(names) > 0 {
+		return names
+	}
+
+	history := append([]v1alpha1.ReleaseStatus{}, ve.Status.ReleaseHistory...)
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[j].RequestTime.Before(&history[i].RequestTime)
+	})
+	for _, rel := range history {
+		add(rel.Apps[r.app.Name].AppDeployment)
+	}
+
+	return names
+}
+
+// recordRelease appends the Release of the AppDeployment to the release
+// history of the VirtualEnvironment. Only the newest records of each app up to
+// the 'history-limit' flag, or DefaultHistoryLimit if it is not set, are kept.
+// The oldest records are dropped if the history is too large for an
+// annotation.
+func (r *repo) recordRelease(ve *v1alpha1.VirtualEnvironment, appDep *v1alpha1.AppDeployment, rollback bool) error {
+	records := append(releaseHistory(ve), ReleaseRecord{
+		App:           appDep.Spec.AppName,
+		AppDeployment: appDep.Name,
+		Version:       appDep.Spec.Version,
+		Commit:        appDep.Spec.Commit,
+		User:          r.gitUser(),
+		Time:          metav1.Now(),
+		Rollback:      rollback,
+	})
+
+	limit := r.cfg.Flags.HistoryLimit
+	if limit <= 0 {
+		limit = DefaultHistoryLimit
+	}
+	count := map[string]int{}
+	kept := make([]ReleaseRecord, 0, len(records))
+	for i := len(records) - 1; i >= 0; i-- {
+		rec := records[i]
+		if count[rec.App]++; count[rec.App] <= limit {
+			kept = append([]ReleaseRecord{rec}, kept...)
+		}
+	}
+
+	var b []byte
+	for {
+		var err error
+		if b, err = json.Marshal(kept); err != nil {
+			return fmt.Errorf("error marshaling release history: %w", err)
+		}
+		// The newest record, the one being added, is always kept.
+		if len(b) <= maxReleaseHistorySize || len(kept) == 1 {
+			break
+		}
+		drop := max(1, len(kept)*(len(b)-maxReleaseHistorySize)/len(b))
+		log.Verbose("Release history of VirtualEnvironment '%s' is too large, dropping %d oldest records.", ve.Name, drop)
+		kept = kept[drop:]
+	}
+	if ve.Annotations == nil {
+		ve.Annotations = map[string]string{}
+	}
+	ve.Annotations[AnnotationReleaseHistory] = string(b)
+
+	return nil
+}
+
+// releaseHistory returns the ReleaseRecords of the VirtualEnvironment, oldest
+// first.
+func releaseHistory(ve *v1alpha1.VirtualEnvironment) []ReleaseRecord {
+	records := []ReleaseRecord{}
+	val, found := ve.Annotations[AnnotationReleaseHistory]
+	if !found {
+		return records
+	}
+	if err := json.Unmarshal([]byte(val), &records); err != nil {
+		log.Warn("Ignoring invalid release history of VirtualEnvironment '%s': %v", ve.Name, err)
+		return []ReleaseRecord{}
+	}
+
+	return records
+}
+
+// gitUser returns the user from the Git config, formatted as 'name <email>'.
+// The name of the current OS user is used if no Git user is configured.
+func (r *repo) gitUser() string {
+	c, err := r.gitRepo.ConfigScoped(gitconfig.GlobalScope)
+	if err != nil {
+		log.Verbose("Error reading Git config: %v", err)
+		return devUser()
+	}
+
+	switch name, email := c.User.Name, c.User.Email; {
+	case name != "" && email != "":
+		return fmt.Sprintf("%s <%s>", name, email)
+	case name != "":
+		return name
+	case email != "":
+		return email
+	default:
+		return devUser()
+	}
+}
@@ -33,16 +33,29 @@ import (
 	"github.com/xigxog/kubefox/utils"
 )
 
-func Init(cfg *config.Config) {
-	initApp(cfg)
+func Init(cfg *config.Config) error {
+	if err := initApp(cfg); err != nil {
+		return err
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), cfg.Flags.Timeout)
 	defer cancel()
 
+	c, err := kubernetes.NewClient(cfg)
+	if err != nil {
+		return err
+	}
+
 	if cfg.Flags.Quickstart || cfg.Flags.GraphQL {
-		c := kubernetes.NewClient(cfg)
-		p := c.CreatePlatform(ctx, "kubefox-demo", "demo")
-		c.WaitPlatformReady(time.Minute*5, p, nil)
+		p, err := c.CreatePlatform(ctx, "kubefox-demo", "demo")
+		if err != nil {
+			return err
+		}
+		waitCtx, waitCancel := context.WithTimeout(context.Background(), time.Minute*5)
+		defer waitCancel()
+		if err := c.WaitPlatformReady(waitCtx, p, nil); err != nil {
+			return err
+		}
 
 		if cfg.Flags.Quickstart {
 			log.Info("KubeFox initialized for the quickstart guide!")
@@ -53,37 +66,44 @@ func Init(cfg *config.Config) {
 	} else {
 		log.InfoNewline()
 		// Creates new platform if none exist.
-		kubernetes.NewClient(cfg).GetPlatform(ctx)
+		if _, err := c.GetPlatform(ctx); err != nil {
+			return err
+		}
 		log.Info("KubeFox App initialization complete!")
 	}
+
+	return nil
 }
 
-func initApp(cfg *config.Config) {
-	cfg.CleanPaths(true)
+func initApp(cfg *config.Config) error {
+	if err := cfg.CleanPaths(true); err != nil {
+		return err
+	}
 
 	if cfg.Flags.Quickstart {
-		initDir(efs.HelloWorldPath, cfg.AppPath)
-		initGit(cfg.RepoPath, cfg)
-		return
+		if err := initDir(efs.HelloWorldPath, cfg.AppPath); err != nil {
+			return err
+		}
+		return initGit(cfg.RepoPath, cfg)
 	}
 
 	if cfg.Flags.GraphQL {
-		initDir(efs.GraphQLPath, cfg.AppPath)
-		initGit(cfg.RepoPath, cfg)
-		return
+		if err := initDir(efs.GraphQLPath, cfg.AppPath); err != nil {
+			return err
+		}
+		return initGit(cfg.RepoPath, cfg)
 	}
 
 	app, err := ReadApp(cfg.AppPath)
 	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		log.Error("An KubeFox App definition already exists but appears to be invalid: %v.", err)
-		if !foxutils.YesNoPrompt("Would you like to reinitialize the app?", true) {
-			return
+		if !cfg.Prompter().YesNo("Would you like to reinitialize the app?", true) {
+			return nil
 		}
 	} else if !errors.Is(err, fs.ErrNotExist) {
 		log.VerboseMarshal(app, "App definition:")
 		log.Info("A valid KubeFox App definition already exists.")
-		initGit(cfg.RepoPath, cfg)
-		return
+		return initGit(cfg.RepoPath, cfg)
 	}
 
 	app = &App{}
@@ -91,10 +111,11 @@ func initApp(cfg *config.Config) {
 	log.InfoNewline()
 	log.Info("To get things started quickly 🦊 Fox can create a 'hello-world' KubeFox App which")
 	log.Info("includes two components and example environments for testing.")
-	if foxutils.YesNoPrompt("Would you like to initialize the 'hello-world' KubeFox App?", false) {
-		initDir(efs.HelloWorldPath, cfg.AppPath)
-		initGit(cfg.RepoPath, cfg)
-		return
+	if cfg.Prompter().YesNo("Would you like to initialize the 'hello-world' KubeFox App?", false) {
+		if err := initDir(efs.HelloWorldPath, cfg.AppPath); err != nil {
+			return err
+		}
+		return initGit(cfg.RepoPath, cfg)
 	}
 	log.InfoNewline()
 	log.Info("🦊 Fox needs to create an KubeFox App definition. The definition is stored in the")
@@ -102,26 +123,36 @@ func initApp(cfg *config.Config) {
 	log.Info("the app. The name is used as part of Kubernetes resource names so it must")
 	log.Info("contain only lowercase alpha-numeric characters and dashes. But don't worry you")
 	log.Info("can enter a more human friendly title and description.")
-	app.Name = foxutils.NamePrompt("KubeFox App", utils.CleanName(cfg.AppPath), true)
-	app.Title = foxutils.InputPrompt("Enter the KubeFox App's title", "", false)
-	app.Description = foxutils.InputPrompt("Enter the KubeFox App's description", "", false)
-
-	WriteApp(cfg.AppPath, app)
-	initGit(cfg.RepoPath, cfg)
+	// The name has a default and other inputs are optional, no errors are
+	// returned.
+	app.Name, _ = cfg.Prompter().Name("KubeFox App", utils.CleanName(cfg.AppPath), true, "")
+	app.Title, _ = cfg.Prompter().Input("Enter the KubeFox App's title", "", false, "")
+	app.Description, _ = cfg.Prompter().Input("Enter the KubeFox App's description", "", false, "")
+
+	if err := WriteApp(cfg.AppPath, app); err != nil {
+		return err
+	}
+	return initGit(cfg.RepoPath, cfg)
 }
 
-func initGit(repoPath string, cfg *config.Config) {
+func initGit(repoPath string, cfg *config.Config) error {
 	wt := osfs.New(repoPath)
 	dot, _ := wt.Chroot(git.GitDirName)
 	s := filesystem.NewStorage(dot, cache.NewObjectLRUDefault())
 	nr, err := git.InitWithOptions(s, wt, git.InitOptions{DefaultBranch: plumbing.Main})
 	alreadyExists := errors.Is(err, git.ErrRepositoryAlreadyExists)
 	if err != nil && !alreadyExists {
-		log.Fatal("Error initializing git repo: %v", err)
+		return fmt.Errorf("error initializing git repo: %w", err)
 	}
 
-	r := New(cfg)
-	foxutils.EnsureDir(r.ComponentsDir())
+	r, err := New(cfg)
+	if err != nil {
+		return err
+	}
+	defer r.cancel()
+	if err := foxutils.EnsureDir(r.ComponentsDir()); err != nil {
+		return err
+	}
 
 	if !alreadyExists {
 		var remoteURL string
@@ -130,7 +161,7 @@ func initGit(repoPath string, cfg *config.Config) {
 		}
 
 		if !(cfg.Flags.Quickstart || cfg.Flags.GraphQL) {
-			remoteURL = foxutils.InputPrompt("Enter URL for remote Git repo", remoteURL, false)
+			remoteURL, _ = cfg.Prompter().Input("Enter URL for remote Git repo", remoteURL, false, "")
 			if remoteURL != "" {
 				_, err := nr.CreateRemote(&gitcfg.RemoteConfig{
 					Name: "origin",
@@ -142,18 +173,24 @@ func initGit(repoPath string, cfg *config.Config) {
 			}
 		}
 
-		r.CommitAll("And so it begins...")
+		if _, err := r.CommitAll("And so it begins..."); err != nil {
+			return err
+		}
 	}
+
+	return nil
 }
 
-func initDir(in, out string) {
+func initDir(in, out string) error {
 	log.Verbose("Writing files from EFS '%s' to '%s", in, out)
 
-	foxutils.EnsureDir(out)
-	fs.WalkDir(efs.EFS, in,
+	if err := foxutils.EnsureDir(out); err != nil {
+		return err
+	}
+	err := fs.WalkDir(efs.EFS, in,
 		func(efsPath string, d fs.DirEntry, err error) error {
 			if err != nil {
-				log.Fatal("Error initializing app: %v", err)
+				return err
 			}
 			if d.IsDir() {
 				return nil
@@ -165,7 +202,9 @@ func initDir(in, out string) {
 			path = filepath.Join(out, path)
 
 			log.Verbose("Writing file '%s'", path)
-			foxutils.EnsureDirForFile(path)
+			if err := foxutils.EnsureDirForFile(path); err != nil {
+				return err
+			}
 			if foxutils.FileExists(path) {
 				log.Verbose("File '%s' exists, skipping...", path)
 				return nil
@@ -173,9 +212,14 @@ func initDir(in, out string) {
 
 			data, _ := efs.EFS.ReadFile(efsPath)
 			if err := os.WriteFile(path, data, 0644); err != nil {
-				log.Fatal("Error creating file: %v", err)
+				return fmt.Errorf("error creating file: %w", err)
 			}
 
 			return nil
 		})
+	if err != nil {
+		return fmt.Errorf("error initializing app: %w", err)
+	}
+
+	return nil
 }
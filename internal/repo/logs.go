@@ -0,0 +1,186 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package repo
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"os/signal"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xigxog/fox/internal/errs"
+	"github.com/xigxog/fox/internal/log"
+	"github.com/xigxog/kubefox/api"
+	"github.com/xigxog/kubefox/utils"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// maxLogLine is the longest log line that can be read, longer lines are
+	// split.
+	maxLogLine = 1024 * 1024
+)
+
+// Logs writes the logs of all pods of the AppDeployment's components to
+// stdout. If compName is provided only that component's logs are written.
+// Each line is prefixed with the component and pod name.
+func (r *repo) Logs(compName string) error {
+	if compName != "" {
+		compName = utils.CleanName(compName)
+	}
+	p, err := r.k8s.GetPlatform(r.ctx)
+	if err != nil {
+		return err
+	}
+
+	name, err := r.appDepName()
+	if err != nil {
+		return err
+	}
+	appDep, err := r.findAppDep(r.ctx, p, name)
+	if err != nil {
+		return fmt.Errorf("error finding AppDeployment '%s': %w", name, err)
+	}
+	if _, found := appDep.Spec.Components[compName]; compName != "" && !found {
+		return errs.New(errs.TypeNotFound, "component '%s' is not part of AppDeployment '%s'", compName, appDep.Name)
+	}
+
+	var grep *regexp.Regexp
+	if r.cfg.Flags.Grep != "" {
+		if grep, err = regexp.Compile(r.cfg.Flags.Grep); err != nil {
+			return errs.Wrap(errs.TypeUsage, err, "invalid 'grep' pattern")
+		}
+	}
+
+	pods, err := r.k8s.ListPlatformPods(r.ctx, p)
+	if err != nil {
+		return fmt.Errorf("error getting component pods: %w", err)
+	}
+	targets := []*corev1.Pod{}
+	for i, pod := range pods {
+		n := pod.Labels[api.LabelK8sComponent]
+		if compName != "" && n != compName {
+			continue
+		}
+		if c, found := appDep.Spec.Components[n]; found && c.Hash == pod.Labels[api.LabelK8sComponentHash] {
+			targets = append(targets, &pods[i])
+		}
+	}
+	if len(targets) == 0 {
+		return errs.New(errs.TypeNotFound, "no component pods found for AppDeployment '%s'", appDep.Name)
+	}
+	log.Info("Streaming logs from %d pods of AppDeployment '%s'.", len(targets), appDep.Name)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if !r.cfg.Flags.Follow {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.cfg.Flags.Timeout)
+		defer cancel()
+	}
+
+	mutex := &sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for _, pod := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			prefix := fmt.Sprintf("[%s/%s] ", pod.Labels[api.LabelK8sComponent], pod.Name)
+			if err := r.streamLogs(ctx, pod, prefix, grep, mutex); err != nil && ctx.Err() == nil {
+				log.Error("Error streaming logs of pod '%s': %v", pod.Name, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return nil
+}
+
+func (r *repo) streamLogs(ctx context.Context, pod *corev1.Pod, prefix string, grep *regexp.Regexp, mutex *sync.Mutex) error {
+	opts := &corev1.PodLogOptions{
+		Follow: r.cfg.Flags.Follow,
+	}
+	if len(pod.Spec.Containers) > 0 {
+		opts.Container = pod.Spec.Containers[0].Name
+	}
+	if r.cfg.Flags.Since > 0 {
+		since := int64(math.Ceil(r.cfg.Flags.Since.Seconds()))
+		opts.SinceSeconds = &since
+	}
+
+	stream, err := r.k8s.StreamLogs(ctx, pod, opts)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLogLine)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if grep != nil && !grep.MatchString(line) {
+			continue
+		}
+		if !r.cfg.Flags.RawLogs {
+			line = prettyLog(line)
+		}
+
+		mutex.Lock()
+		log.Printf("%s%s\n", prefix, line)
+		mutex.Unlock()
+	}
+
+	return scanner.Err()
+}
+
+// prettyLog formats a line written by the JSON logger used by KubeFox
+// components. Lines that are not JSON are returned unchanged.
+func prettyLog(line string) string {
+	if !strings.HasPrefix(line, "{") {
+		return line
+	}
+	entry := map[string]any{}
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		return line
+	}
+
+	b := &strings.Builder{}
+	if ts, ok := entry["ts"].(float64); ok {
+		sec, frac := math.Modf(ts)
+		b.WriteString(time.Unix(int64(sec), int64(frac*1e9)).Format("15:04:05.000 "))
+	}
+	fmt.Fprintf(b, "%-5v %v", entry["level"], entry["msg"])
+
+	keys := make([]string, 0, len(entry))
+	for k := range entry {
+		switch k {
+		case "ts", "level", "msg", "caller", "stacktrace":
+		default:
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(b, " %s=%v", k, entry[k])
+	}
+	if st, ok := entry["stacktrace"].(string); ok && st != "" {
+		b.WriteString("\n\t" + strings.ReplaceAll(st, "\n", "\n\t"))
+	}
+
+	return b.String()
+}
@@ -0,0 +1,413 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package repo
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/match"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/xigxog/fox/internal/config"
+	"github.com/xigxog/fox/internal/log"
+)
+
+const (
+	// nativeBaseImage must match the runtime stage of the default Dockerfile
+	// found at efs/Dockerfile.
+	nativeBaseImage = "ghcr.io/xigxog/base:v0.2.0"
+	nativeBinPath   = "/component"
+
+	// LabelCompDef holds the component definition exported when the image was
+	// built, so it can be read without running the image's binary.
+	LabelCompDef = "kubefox.xigxog.io/component-definition"
+)
+
+// nativeBuilder compiles Go components on the host and assembles the OCI
+// image directly, without Docker or a Dockerfile. Images are stored in an OCI
+// image layout on the local filesystem.
+type nativeBuilder struct {
+	cfg      *config.Config
+	layout   layout.Path
+	platform v1.Platform
+
+	// Protects the index of the OCI layout.
+	mutex sync.Mutex
+}
+
+func newNativeBuilder(cfg *config.Config) (*nativeBuilder, error) {
+	dir := cfg.Flags.OCILayout
+	if dir == "" {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(cache, "kubefox", "oci")
+	}
+
+	l, err := layout.FromPath(dir)
+	if err != nil {
+		log.Verbose("Creating OCI image layout '%s'", dir)
+		if l, err = layout.Write(dir, empty.Index); err != nil {
+			return nil, fmt.Errorf("error creating OCI image layout '%s': %w", dir, err)
+		}
+	}
+
+	return &nativeBuilder{
+		cfg:    cfg,
+		layout: l,
+		// Images target the architecture of the host, matching the default
+		// behavior of Docker.
+		platform: v1.Platform{OS: "linux", Architecture: runtime.GOARCH},
+	}, nil
+}
+
+func (b *nativeBuilder) Build(ctx context.Context, req *BuildRequest) error {
+	if req.CustomDockerfile {
+		return fmt.Errorf("native builder does not support custom Dockerfiles, use the 'docker' or 'buildah' builder")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		return fmt.Errorf("native builder requires the 'go' CLI: %w", err)
+	}
+
+	tmp, err := os.MkdirTemp("", "fox-native-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	bin := filepath.Join(tmp, "component")
+	if err := b.compile(ctx, req, bin, b.platform.OS, b.platform.Architecture); err != nil {
+		return fmt.Errorf("error compiling component: %w", err)
+	}
+
+	// The image's binary cannot run on hosts other than Linux, a separate host
+	// binary is compiled to export the component definition.
+	hostBin := bin
+	if b.platform.OS != runtime.GOOS || b.platform.Architecture != runtime.GOARCH {
+		hostBin = filepath.Join(tmp, "component-host")
+		if err := b.compile(ctx, req, hostBin, runtime.GOOS, runtime.GOARCH); err != nil {
+			return fmt.Errorf("error compiling component for host: %w", err)
+		}
+	}
+	compDef, err := exportCompDef(ctx, hostBin)
+	if err != nil {
+		return fmt.Errorf("error exporting component definition: %w", err)
+	}
+
+	log.FromContext(ctx).Verbose("Fetching base image '%s'", nativeBaseImage)
+	base, err := b.remoteImage(ctx, nativeBaseImage)
+	if err != nil {
+		return fmt.Errorf("error fetching base image '%s': %w", nativeBaseImage, err)
+	}
+
+	binLayer, err := binaryLayer(bin, nativeBinPath)
+	if err != nil {
+		return err
+	}
+	img, err := mutate.AppendLayers(base, binLayer)
+	if err != nil {
+		return err
+	}
+
+	cfgFile, err := img.ConfigFile()
+	if err != nil {
+		return err
+	}
+	cfgFile = cfgFile.DeepCopy()
+	cfgFile.Created = v1.Time{Time: time.Now()}
+	cfgFile.Config.Entrypoint = []string{nativeBinPath}
+	cfgFile.Config.Cmd = nil
+	if cfgFile.Config.Labels == nil {
+		cfgFile.Config.Labels = map[string]string{}
+	}
+	for k, v := range req.Labels {
+		cfgFile.Config.Labels[k] = v
+	}
+	cfgFile.Config.Labels[LabelCompDef] = string(compDef)
+	if img, err = mutate.ConfigFile(img, cfgFile); err != nil {
+		return err
+	}
+
+	return b.writeLocal(req.Image, img)
+}
+
+func (b *nativeBuilder) compile(ctx context.Context, req *BuildRequest, out, goos, goarch string) error {
+	arg := func(k string) string {
+		if v := req.BuildArgs[k]; v != nil {
+			return *v
+		}
+		return ""
+	}
+	ldflags := strings.Join([]string{
+		"-X github.com/xigxog/kubefox/build.date=" + arg("BUILD_DATE"),
+		"-X github.com/xigxog/kubefox/build.component=" + arg("COMPONENT"),
+		"-X github.com/xigxog/kubefox/build.hash=" + arg("COMPONENT_HASH"),
+		"-X github.com/xigxog/kubefox/build.rootCommit=" + arg("ROOT_COMMIT"),
+		"-X github.com/xigxog/kubefox/build.headRef=" + arg("HEAD_REF"),
+		"-X github.com/xigxog/kubefox/build.tagRef=" + arg("TAG_REF"),
+	}, " ")
+
+	args := []string{"build", "-C", filepath.Join(req.RepoPath, req.ComponentDir), "-o", out, "-ldflags", ldflags}
+	if req.NoCache {
+		args = append(args, "-a")
+	}
+	log.FromContext(ctx).Verbose("Running command 'go %s'", strings.Join(args, " "))
+
+	cmd := exec.CommandContext(ctx, "go", args...)
+	cmd.Env = append(os.Environ(),
+		"CGO_ENABLED=0",
+		"GOOS="+goos,
+		"GOARCH="+goarch,
+	)
+	if o, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(o)))
+	}
+
+	return nil
+}
+
+func (b *nativeBuilder) IsImageLocal(ctx context.Context, img string) bool {
+	_, err := b.localImage(img)
+	return err == nil
+}
+
+func (b *nativeBuilder) IsImageRemote(ctx context.Context, img string) (bool, error) {
+	return remoteImageExists(ctx, b.cfg, img)
+}
+
+func (b *nativeBuilder) Pull(ctx context.Context, img string) error {
+	i, err := b.remoteImage(ctx, img)
+	if err != nil {
+		return err
+	}
+
+	return b.writeLocal(img, i)
+}
+
+func (b *nativeBuilder) Push(ctx context.Context, img string) error {
+	i, err := b.localImage(img)
+	if err != nil {
+		return err
+	}
+	ref, err := name.ParseReference(img)
+	if err != nil {
+		return err
+	}
+	opts, err := remoteOpts(ctx, b.cfg, ref)
+	if err != nil {
+		return err
+	}
+
+	return remote.Write(ref, i, opts...)
+}
+
+func (b *nativeBuilder) LoadKind(ctx context.Context, img, cluster string) error {
+	i, err := b.localImage(img)
+	if err != nil {
+		return err
+	}
+	tag, err := name.NewTag(img)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.MkdirTemp("", "fox-native-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	archive := filepath.Join(tmp, "image.tar")
+	if err := tarball.WriteToFile(archive, tag, i); err != nil {
+		return err
+	}
+
+	_, err = runCmd(ctx, "kind", "load", "image-archive", "--name="+cluster, archive)
+	return err
+}
+
+// ExportCompDef returns the component definition stored in the image's labels
+// by the native builder. Images built by other builders do not have the label,
+// their binary is extracted from the image and run on the host. This requires
+// the image to target the OS and architecture of the host.
+func (b *nativeBuilder) ExportCompDef(ctx context.Context, img string) ([]byte, error) {
+	i, err := b.localImage(img)
+	if err != nil {
+		if i, err = b.remoteImage(ctx, img); err != nil {
+			return nil, err
+		}
+	}
+	cfgFile, err := i.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if compDef := cfgFile.Config.Labels[LabelCompDef]; compDef != "" {
+		return []byte(compDef), nil
+	}
+	if cfgFile.OS != runtime.GOOS || cfgFile.Architecture != runtime.GOARCH {
+		return nil, fmt.Errorf("image platform '%s/%s' cannot be run on host '%s/%s'",
+			cfgFile.OS, cfgFile.Architecture, runtime.GOOS, runtime.GOARCH)
+	}
+	if len(cfgFile.Config.Entrypoint) == 0 {
+		return nil, fmt.Errorf("image '%s' does not define an entrypoint", img)
+	}
+
+	tmp, err := os.MkdirTemp("", "fox-native-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	bin := filepath.Join(tmp, "component")
+	if err := extractFile(i, cfgFile.Config.Entrypoint[0], bin); err != nil {
+		return nil, err
+	}
+
+	return exportCompDef(ctx, bin, cfgFile.Config.Entrypoint[1:]...)
+}
+
+// exportCompDef runs the component binary with the '-export' flag and returns
+// the component definition it outputs.
+func exportCompDef(ctx context.Context, bin string, args ...string) ([]byte, error) {
+	args = append(append([]string{}, args...), "-export")
+	out, err := exec.CommandContext(ctx, bin, args...).Output()
+	if err != nil {
+		return nil, cmdErr(err)
+	}
+
+	return out, nil
+}
+
+func (b *nativeBuilder) remoteImage(ctx context.Context, img string) (v1.Image, error) {
+	ref, err := name.ParseReference(img)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := remoteOpts(ctx, b.cfg, ref)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, remote.WithPlatform(b.platform))
+
+	return remote.Image(ref, opts...)
+}
+
+func (b *nativeBuilder) localImage(img string) (v1.Image, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	idx, err := b.layout.ImageIndex()
+	if err != nil {
+		return nil, err
+	}
+	mf, err := idx.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	for _, desc := range mf.Manifests {
+		if desc.Annotations[specsv1.AnnotationRefName] == img {
+			return b.layout.Image(desc.Digest)
+		}
+	}
+
+	return nil, fmt.Errorf("image '%s' not found in OCI image layout", img)
+}
+
+func (b *nativeBuilder) writeLocal(img string, i v1.Image) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.layout.ReplaceImage(i,
+		match.Annotation(specsv1.AnnotationRefName, img),
+		layout.WithAnnotations(map[string]string{specsv1.AnnotationRefName: img}),
+		layout.WithPlatform(b.platform),
+	)
+}
+
+// binaryLayer creates an image layer containing the file at src placed at
+// dst.
+func binaryLayer(src, dst string) (v1.Layer, error) {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	err = w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     strings.TrimPrefix(dst, "/"),
+		Size:     int64(len(data)),
+		Mode:     0755,
+		ModTime:  time.Time{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+}
+
+// extractFile writes the file at src in the image's filesystem to dst.
+func extractFile(img v1.Image, src, dst string) error {
+	rc := mutate.Extract(img)
+	defer rc.Close()
+
+	src = path.Clean("/" + src)
+	r := tar.NewReader(rc)
+	for {
+		hdr, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("file '%s' not found in image", src)
+		}
+		if err != nil {
+			return err
+		}
+		if path.Clean("/"+hdr.Name) != src {
+			continue
+		}
+
+		f, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(f, r)
+		return err
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package repo
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/xigxog/kubefox/api"
+	"github.com/xigxog/kubefox/api/kubernetes/v1alpha1"
+	"github.com/xigxog/kubefox/k8s"
+)
+
+type PlanAction string
+
+const (
+	PlanActionAdd       PlanAction = "add"
+	PlanActionRemove    PlanAction = "remove"
+	PlanActionChange    PlanAction = "change"
+	PlanActionUnchanged PlanAction = "unchanged"
+)
+
+// ReleasePlan describes the changes releasing an AppDeployment to a
+// VirtualEnvironment would make.
+type ReleasePlan struct {
+	VirtualEnvironment string `json:"virtualEnvironment"`
+	App                string `json:"app"`
+	// Current is the AppDeployment currently released, nil if the app has not
+	// been released to the VirtualEnvironment.
+	Current   *PlanAppDeployment `json:"current,omitempty"`
+	Candidate PlanAppDeployment  `json:"candidate"`
+
+	Components []PlanComponent `json:"components"`
+	// MissingVars are the vars required by the candidate that are not set by
+	// the VirtualEnvironment or its Environment.
+	MissingVars []PlanVar `json:"missingVars,omitempty"`
+	// Problems that would prevent the Release from being activated.
+	Problems api.Problems `json:"problems,omitempty"`
+}
+
+type PlanAppDeployment struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	Commit  string `json:"commit,omitempty"`
+}
+
+type PlanComponent struct {
+	Name          string     `json:"name"`
+	Action        PlanAction `json:"action"`
+	CurrentHash   string     `json:"currentHash,omitempty"`
+	CandidateHash string     `json:"candidateHash,omitempty"`
+	// Routes are identified by their rule.
+	RoutesAdded         []string `json:"routesAdded,omitempty"`
+	RoutesRemoved       []string `json:"routesRemoved,omitempty"`
+	DependenciesAdded   []string `json:"dependenciesAdded,omitempty"`
+	DependenciesRemoved []string `json:"dependenciesRemoved,omitempty"`
+}
+
+type PlanVar struct {
+	Name       string   `json:"name"`
+	Components []string `json:"components"`
+}
+
+// PlanRelease compares the AppDeployment currently released to the
+// VirtualEnvironment with the candidate AppDeployment without changing the
+// Release.
+func (r *repo) PlanRelease(appDepId string) (*ReleasePlan, error) {
+	platform, err := r.k8s.GetPlatform(r.ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	appDep, err := r.findAppDep(r.ctx, platform, appDepId)
+	if err != nil {
+		return nil, fmt.Errorf("error finding AppDeployment: %w", err)
+	}
+	if appDep.Labels[LabelDirty] == "true" {
+		return nil, fmt.Errorf("%w: AppDeployment '%s'", ErrDirtyRelease, appDep.Name)
+	}
+	ve, err := r.getVirtualEnv(platform)
+	if err != nil {
+		return nil, err
+	}
+
+	var cur *v1alpha1.AppDeployment
+	if ve.Spec.Release != nil {
+		if relApp, found := ve.Spec.Release.Apps[appDep.Spec.AppName]; found {
+			cur = &v1alpha1.AppDeployment{}
+			if err := r.k8s.Get(r.ctx, k8s.Key(ve.Namespace, relApp.AppDeployment), cur); k8s.IgnoreNotFound(err) != nil {
+				return nil, fmt.Errorf("error getting released AppDeployment: %w", err)
+			} else if err != nil {
+				// The released AppDeployment was deleted, all components
+				// are shown as added.
+				cur = nil
+			}
+		}
+	}
+
+	data, err := r.releaseData(ve)
+	if err != nil {
+		return nil, err
+	}
+	problems, err := r.releaseProblems(appDep, data)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &ReleasePlan{
+		VirtualEnvironment: ve.Name,
+		App:                appDep.Spec.AppName,
+		Candidate:          planAppDep(appDep),
+		Components:         planComponents(cur, appDep),
+		MissingVars:        missingVars(appDep, data),
+		Problems:           problems,
+	}
+	if cur != nil {
+		c := planAppDep(cur)
+		plan.Current = &c
+	}
+
+	return plan, nil
+}
+
+func planAppDep(appDep *v1alpha1.AppDeployment) PlanAppDeployment {
+	return PlanAppDeployment{
+		Name:    appDep.Name,
+		Version: appDep.Spec.Version,
+		Commit:  appDep.Spec.Commit,
+	}
+}
+
+// planComponents compares the components of the current and candidate
+// AppDeployments. If cur is nil all components are added.
+func planComponents(cur, cand *v1alpha1.AppDeployment) []PlanComponent {
+	curComps := map[string]*api.ComponentDefinition{}
+	if cur != nil {
+		curComps = cur.Spec.Components
+	}
+
+	names := map[string]bool{}
+	for n := range curComps {
+		names[n] = true
+	}
+	for n := range cand.Spec.Components {
+		names[n] = true
+	}
+
+	comps := []PlanComponent{}
+	for _, n := range sortedKeys(names) {
+		c, cd := curComps[n], cand.Spec.Components[n]
+		pc := PlanComponent{Name: n}
+		switch {
+		case c == nil:
+			pc.Action = PlanActionAdd
+			pc.CandidateHash = cd.Hash
+		case cd == nil:
+			pc.Action = PlanActionRemove
+			pc.CurrentHash = c.Hash
+		default:
+			pc.CurrentHash, pc.CandidateHash = c.Hash, cd.Hash
+		}
+		pc.RoutesAdded, pc.RoutesRemoved = diffSets(routeRules(c), routeRules(cd))
+		pc.DependenciesAdded, pc.DependenciesRemoved = diffSets(depNames(c), depNames(cd))
+
+		if pc.Action == "" {
+			if pc.CurrentHash != pc.CandidateHash || len(pc.RoutesAdded) > 0 || len(pc.RoutesRemoved) > 0 ||
+				len(pc.DependenciesAdded) > 0 || len(pc.DependenciesRemoved) > 0 {
+				pc.Action = PlanActionChange
+			} else {
+				pc.Action = PlanActionUnchanged
+			}
+		}
+		comps = append(comps, pc)
+	}
+
+	return comps
+}
+
+// missingVars returns the vars required by the components of the
+// AppDeployment that are not set in data. All vars used by routes are
+// required.
+func missingVars(appDep *v1alpha1.AppDeployment, data *api.Data) []PlanVar {
+	missing := map[string][]string{}
+	add := func(schema api.EnvVarSchema, compName string, required bool) {
+		for name, def := range schema {
+			if _, found := data.Vars[name]; found || (!required && !def.Required) {
+				continue
+			}
+			if !slices.Contains(missing[name], compName) {
+				missing[name] = append(missing[name], compName)
+			}
+		}
+	}
+	for compName, comp := range appDep.Spec.Components {
+		add(comp.EnvVarSchema, compName, false)
+		for _, route := range comp.Routes {
+			add(route.EnvVarSchema, compName, true)
+		}
+	}
+
+	vars := []PlanVar{}
+	for _, name := range sortedKeys(missing) {
+		comps := missing[name]
+		slices.Sort(comps)
+		vars = append(vars, PlanVar{Name: name, Components: comps})
+	}
+
+	return vars
+}
+
+func routeRules(comp *api.ComponentDefinition) []string {
+	if comp == nil {
+		return nil
+	}
+	rules := make([]string, 0, len(comp.Routes))
+	for _, route := range comp.Routes {
+		rules = append(rules, route.Rule)
+	}
+
+	return rules
+}
+
+func depNames(comp *api.ComponentDefinition) []string {
+	if comp == nil {
+		return nil
+	}
+
+	return sortedKeys(comp.Dependencies)
+}
+
+// diffSets returns the values of cand not in cur and the values of cur not in
+// cand.
+func diffSets(cur, cand []string) (added, removed []string) {
+	for _, v := range cand {
+		if !slices.Contains(cur, v) {
+			added = append(added, v)
+		}
+	}
+	for _, v := range cur {
+		if !slices.Contains(cand, v) {
+			removed = append(removed, v)
+		}
+	}
+
+	return added, removed
+}
@@ -0,0 +1,85 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package repo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/xigxog/fox/internal/config"
+	"github.com/xigxog/fox/internal/log"
+)
+
+// regCreds returns the username and token used to access the container
+// registry. If no token is configured both values are empty.
+func regCreds(cfg *config.Config) (string, string) {
+	token := cfg.GetContainerRegistry().Token
+	if cfg.GitHub.Token != "" {
+		token = cfg.GitHub.Token
+	}
+	if token == "" {
+		return "", ""
+	}
+
+	user := cfg.GetContainerRegistry().Username
+	if user == "" {
+		user = "kubefox"
+	}
+
+	return user, token
+}
+
+// regAuth returns the authenticator for the container registry. If no
+// credentials are configured the default keychain is used, which reads the
+// Docker and Podman auth files.
+func regAuth(cfg *config.Config, ref name.Reference) (authn.Authenticator, error) {
+	if user, token := regCreds(cfg); token != "" {
+		return &authn.Basic{Username: user, Password: token}, nil
+	}
+
+	return authn.DefaultKeychain.Resolve(ref.Context())
+}
+
+func remoteOpts(ctx context.Context, cfg *config.Config, ref name.Reference) ([]remote.Option, error) {
+	auth, err := regAuth(cfg, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	return []remote.Option{remote.WithContext(ctx), remote.WithAuth(auth)}, nil
+}
+
+func remoteImageExists(ctx context.Context, cfg *config.Config, img string) (bool, error) {
+	ref, err := name.ParseReference(img)
+	if err != nil {
+		return false, err
+	}
+	opts, err := remoteOpts(ctx, cfg, ref)
+	if err != nil {
+		return false, err
+	}
+
+	desc, err := remote.Head(ref, opts...)
+	if err != nil {
+		var tErr *transport.Error
+		if errors.As(err, &tErr) && tErr.StatusCode == http.StatusNotFound {
+			return false, nil
+		}
+		log.FromContext(ctx).Verbose("%s", err)
+		return false, err
+	}
+	log.FromContext(ctx).Verbose("Digest: %s", desc.Digest)
+
+	return true, nil
+}
@@ -13,8 +13,8 @@ import (
 	"fmt"
 	"strconv"
 
+	"github.com/xigxog/fox/internal/errs"
 	"github.com/xigxog/fox/internal/log"
-	"github.com/xigxog/fox/internal/utils"
 	"github.com/xigxog/kubefox/api"
 	common "github.com/xigxog/kubefox/api/kubernetes"
 	"github.com/xigxog/kubefox/api/kubernetes/v1alpha1"
@@ -24,25 +24,79 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-func (r *repo) Release(appDepId string) *v1alpha1.VirtualEnvironment {
-
-	platform := r.k8s.GetPlatform(r.ctx)
+func (r *repo) Release(appDepId string) (*v1alpha1.VirtualEnvironment, error) {
+	platform, err := r.k8s.GetPlatform(r.ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	appDep, err := r.findAppDep(r.ctx, platform, appDepId)
 	if err != nil {
-		log.Fatal("Error finding AppDeployment: %v", err)
+		return nil, fmt.Errorf("error finding AppDeployment: %w", err)
+	}
+	if appDep.Labels[LabelDirty] == "true" {
+		return nil, fmt.Errorf("%w: AppDeployment '%s'", ErrDirtyRelease, appDep.Name)
 	}
+	ve, err := r.getVirtualEnv(platform)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.validateRelease(appDep, ve); err != nil {
+		return nil, err
+	}
+
+	return r.updateRelease(platform, ve, appDep, false)
+}
+
+// getVirtualEnv returns the VirtualEnvironment named by the 'virtual-env' flag.
+func (r *repo) getVirtualEnv(platform *v1alpha1.Platform) (*v1alpha1.VirtualEnvironment, error) {
 	ve := &v1alpha1.VirtualEnvironment{}
 	if err := r.k8s.Get(r.ctx, k8s.Key(platform.Namespace, r.cfg.Flags.VirtEnv), ve); err != nil {
-		log.Fatal("Error getting VirtualEnvironment: %v", err)
+		return nil, fmt.Errorf("error getting VirtualEnvironment: %w", err)
+	}
+
+	return ve, nil
+}
+
+// validateRelease checks that the AppDeployment can be released to the
+// VirtualEnvironment. If problems are found the user is asked to confirm the
+// Release.
+func (r *repo) validateRelease(appDep *v1alpha1.AppDeployment, ve *v1alpha1.VirtualEnvironment) error {
+	data, err := r.releaseData(ve)
+	if err != nil {
+		return err
 	}
+	problems, err := r.releaseProblems(appDep, data)
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		log.InfoMarshal(problems, "Release problems:")
+		if !r.cfg.Prompter().YesNo("Problems that would prevent Release activation exist, continue?", false) {
+			return fmt.Errorf("%w: %d found", ErrReleaseProblems, len(problems))
+		}
+	}
+
+	return nil
+}
+
+// releaseData returns the data of the VirtualEnvironment merged with the data
+// of its Environment.
+func (r *repo) releaseData(ve *v1alpha1.VirtualEnvironment) (*api.Data, error) {
 	env := &v1alpha1.Environment{}
 	if err := r.k8s.Get(r.ctx, k8s.Key("", ve.Spec.Environment), env); err != nil {
-		log.Fatal("Error getting Environment: %v", err)
+		return nil, fmt.Errorf("error getting Environment: %w", err)
 	}
-	ve.Data.Import(&env.Data)
+	data := ve.Data.DeepCopy()
+	data.Import(&env.Data)
+
+	return data, nil
+}
 
-	problems, err := appDep.Validate(&ve.Data,
+// releaseProblems returns the problems that would prevent a Release of the
+// AppDeployment using data from being activated.
+func (r *repo) releaseProblems(appDep *v1alpha1.AppDeployment, data *api.Data) (api.Problems, error) {
+	problems, err := appDep.Validate(data,
 		func(name string, typ api.ComponentType) (common.Adapter, error) {
 			switch typ {
 			case api.ComponentTypeHTTPAdapter:
@@ -54,15 +108,18 @@ func (r *repo) Release(appDepId string) *v1alpha1.VirtualEnvironment {
 			}
 		})
 	if err != nil {
-		log.Fatal("Error validating Release: %v", err)
-	}
-	if len(problems) > 0 {
-		log.InfoMarshal(problems, "Release problems:")
-		if !utils.YesNoPrompt("Problems that would prevent Release activation exist, continue?", false) {
-			log.Fatal("Release aborted.")
-		}
+		return nil, fmt.Errorf("error validating Release: %w", err)
 	}
 
+	return problems, nil
+}
+
+// updateRelease sets the AppDeployment as the app's Release of the
+// VirtualEnvironment and records the change in the VirtualEnvironment's
+// release history.
+func (r *repo) updateRelease(platform *v1alpha1.Platform, ve *v1alpha1.VirtualEnvironment,
+	appDep *v1alpha1.AppDeployment, rollback bool) (*v1alpha1.VirtualEnvironment, error) {
+
 	origVE := ve.DeepCopy()
 	if ve.Spec.Release == nil {
 		ve.Spec.Release = &v1alpha1.Release{}
@@ -75,16 +132,21 @@ func (r *repo) Release(appDepId string) *v1alpha1.VirtualEnvironment {
 		AppDeployment: appDep.Name,
 		Version:       appDep.Spec.Version,
 	}
+	if err := r.recordRelease(ve, appDep, rollback); err != nil {
+		return nil, err
+	}
 
 	if err := r.k8s.Merge(r.ctx, ve, origVE); err != nil {
-		log.Fatal("Error updating Release: %v", err)
+		return nil, fmt.Errorf("error updating Release: %w", err)
 	}
 
-	r.waitForReady(platform, &appDep.Spec)
+	if err := r.waitForReady(platform, appDep, ve); err != nil {
+		return nil, err
+	}
 
 	// Get updated status.
 	if err := r.k8s.Get(r.ctx, k8s.Key(ve.Namespace, ve.Name), ve); err != nil {
-		log.Fatal("Error getting updated VirtualEnvironment: %v", err)
+		return nil, fmt.Errorf("error getting updated VirtualEnvironment: %w", err)
 	}
 
 	ve.TypeMeta = metav1.TypeMeta{
@@ -92,7 +154,7 @@ func (r *repo) Release(appDepId string) *v1alpha1.VirtualEnvironment {
 		APIVersion: v1alpha1.GroupVersion.Identifier(),
 	}
 
-	return ve
+	return ve, nil
 }
 
 func (r *repo) findAppDep(ctx context.Context, platform *v1alpha1.Platform, appDepId string) (*v1alpha1.AppDeployment, error) {
@@ -126,12 +188,15 @@ func (r *repo) findAppDep(ctx context.Context, platform *v1alpha1.Platform, appD
 		case l == 1:
 			return &appDepList.Items[0], nil
 		case l > 1:
+			if r.cfg.Prompter().NoInput {
+				return nil, errs.New(errs.TypeUsage, "found %d AppDeployments matching '%s', provide the AppDeployment's name to select one", l, appDepId)
+			}
 			log.Info("Found %d matching AppDeployments.", l)
 			return r.pickAppDep(appDepList), nil
 		}
 	}
 
-	return nil, core.ErrNotFound()
+	return nil, errs.New(errs.TypeNotFound, "AppDeployment '%s' not found", appDepId)
 }
 
 func (r *repo) pickAppDep(appDepList *v1alpha1.AppDeploymentList) *v1alpha1.AppDeployment {
@@ -10,15 +10,11 @@ package repo
 
 import (
 	"context"
-	"crypto/md5"
-	"encoding/hex"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
-	"strings"
+	"sync"
 
-	docker "github.com/docker/docker/client"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
@@ -36,7 +32,16 @@ type repo struct {
 
 	gitRepo *git.Repository
 	k8s     *kubernetes.Client
-	docker  *docker.Client
+	builder Builder
+
+	// dirty caches the synthetic commit, see GetDirtyCommit.
+	dirty      *DirtyCommit
+	dirtyDone  bool
+	dirtyMutex sync.Mutex
+	// ignoreUncommitted is set by the deprecated
+	// FOX_DRAGON_IGNORE_UNCOMMITTED env var, uncommitted changes are ignored
+	// as if the worktree were clean.
+	ignoreUncommitted bool
 
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -47,42 +52,75 @@ type App struct {
 	Description       string `json:"description,omitempty"`
 	Name              string `json:"name"`
 	ContainerRegistry string `json:"containerRegistry,omitempty"`
+
+	Components map[string]*AppComponent `json:"components,omitempty" yaml:"components,omitempty"`
 }
 
-func New(cfg *config.Config) *repo {
-	cfg.CleanPaths(false)
+type AppComponent struct {
+	// Inputs are additional paths, relative to the app directory, whose
+	// contents are included in the component's hash. Use for shared packages
+	// or other files outside of the component directory that are part of its
+	// build.
+	Inputs []string `json:"inputs,omitempty" yaml:"inputs,omitempty"`
+}
 
-	if !strings.HasPrefix(cfg.AppPath, cfg.RepoPath) {
-		log.Fatal("The app is not part of the Git repo.")
+// New opens the Git repo containing the app. Operations on the repo are
+// canceled once the 'timeout' flag is reached.
+func New(cfg *config.Config) (*repo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Flags.Timeout)
+	r, err := Open(ctx, cfg)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	r.cancel = cancel
+
+	return r, nil
+}
+
+// Open opens the Git repo containing the app. All operations on the repo use
+// ctx.
+func Open(ctx context.Context, cfg *config.Config) (*repo, error) {
+	if err := cfg.CleanPaths(false); err != nil {
+		return nil, err
 	}
 
 	app, err := ReadApp(cfg.AppPath)
 	if err != nil {
-		log.Fatal("Error reading the repo's 'app.yaml', try running 'fox init': %v", err)
+		return nil, fmt.Errorf("error reading the repo's 'app.yaml', try running 'fox init': %w", err)
 	}
 
 	log.Verbose("Opening git repo '%s'", cfg.RepoPath)
 	gitRepo, err := git.PlainOpen(cfg.RepoPath)
 	if err != nil {
-		log.Fatal("Error opening git repo '%s': %v", cfg.RepoPath, err)
+		return nil, fmt.Errorf("error opening git repo '%s': %w", cfg.RepoPath, err)
 	}
 
-	d, err := docker.NewClientWithOpts(docker.FromEnv, docker.WithAPIVersionNegotiation())
+	builder, err := NewBuilder(cfg)
 	if err != nil {
-		log.Fatal("Error creating Docker client: %v", err)
+		return nil, fmt.Errorf("error creating image builder: %w", err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), cfg.Flags.Timeout)
+	k8s, err := kubernetes.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
 
-	return &repo{
-		cfg:     cfg,
-		app:     app,
-		gitRepo: gitRepo,
-		k8s:     kubernetes.NewClient(cfg),
-		docker:  d,
-		ctx:     ctx,
-		cancel:  cancel,
+	ignoreUncommitted := os.Getenv("FOX_DRAGON_IGNORE_UNCOMMITTED") == "true"
+	if ignoreUncommitted {
+		log.Warn("FOX_DRAGON_IGNORE_UNCOMMITTED is deprecated and will be removed, set the 'dirty' flag instead.")
 	}
+
+	return &repo{
+		cfg:               cfg,
+		app:               app,
+		gitRepo:           gitRepo,
+		k8s:               k8s,
+		builder:           builder,
+		ignoreUncommitted: ignoreUncommitted,
+		ctx:               ctx,
+		cancel:            func() {},
+	}, nil
 }
 
 func ReadApp(path string) (*App, error) {
@@ -101,56 +139,63 @@ func ReadApp(path string) (*App, error) {
 	return app, nil
 }
 
-func WriteApp(path string, app *App) {
+func WriteApp(path string, app *App) error {
 	appPath := filepath.Join(path, "app.yaml")
 	b, err := yaml.Marshal(app)
 	if err != nil {
-		log.Fatal("Error marshaling app definition: %v", err)
+		return fmt.Errorf("error marshaling app definition: %w", err)
+	}
+	if err := foxutils.EnsureDirForFile(appPath); err != nil {
+		return err
 	}
-	foxutils.EnsureDirForFile(appPath)
 	if err := os.WriteFile(appPath, b, 0644); err != nil {
-		log.Fatal("Error writing app definition file: %v", err)
+		return fmt.Errorf("error writing app definition file: %w", err)
 	}
+
+	return nil
 }
 
-func (r *repo) CommitAll(msg string) string {
+func (r *repo) CommitAll(msg string) (string, error) {
 	w, err := r.gitRepo.Worktree()
 	if err != nil {
-		log.Fatal("Error accessing git worktree: %v", err)
+		return "", fmt.Errorf("error accessing git worktree: %w", err)
 	}
 	if _, err = w.Add("."); err != nil {
-		log.Fatal("Error adding files to worktree: %v", err)
+		return "", fmt.Errorf("error adding files to worktree: %w", err)
 	}
 	hash, err := w.Commit(msg, &git.CommitOptions{})
 	if err != nil {
-		log.Fatal("Error committing changes: %v", err)
+		return "", fmt.Errorf("error committing changes: %w", err)
 	}
 	log.Verbose("Changes committed; commit hash '%s'", hash)
 
-	return hash.String()
+	return hash.String(), nil
 }
 
-func (r *repo) CreateTag(tag string) *plumbing.Reference {
-	if filepath.Base(r.GetTagRef()) == "tag" {
+func (r *repo) CreateTag(tag string) (*plumbing.Reference, error) {
+	if ref, _ := r.GetTagRef(); filepath.Base(ref) == "tag" {
 		log.Info("Tag '%s' for commot '%s' exists.", tag)
 	}
 
 	log.Info("Creating tag '%s'.", tag)
 	h, err := r.gitRepo.Head()
 	if err != nil {
-		log.Fatal("Error opening head ref of git repo: %v", err)
+		return nil, fmt.Errorf("error opening head ref of git repo: %w", err)
 	}
 	ref, err := r.gitRepo.CreateTag(tag, h.Hash(), nil)
 	if err != nil {
-		log.Fatal("Error creating tag '%s': %v", tag, err)
+		return nil, fmt.Errorf("error creating tag '%s': %w", tag, err)
 	}
-	return ref
+	return ref, nil
 }
 
-func (r *repo) GetCompImageFromDir(compDirName string) string {
+func (r *repo) GetCompImageFromDir(compDirName string) (string, error) {
 	name := utils.CleanName(compDirName)
-	hash := r.GetCompHash(compDirName)
-	return r.GetCompImage(name, hash)
+	hash, err := r.compHash(compDirName)
+	if err != nil {
+		return "", err
+	}
+	return r.GetCompImage(name, hash), nil
 }
 
 func (r *repo) GetCompImage(name, hash string) string {
@@ -166,28 +211,28 @@ func (r *repo) GetRepoURL() string {
 	return o.Config().URLs[0]
 }
 
-func (r *repo) GetHeadRef() string {
+func (r *repo) GetHeadRef() (string, error) {
 	gitRef, err := r.gitRepo.Head()
 	if err != nil {
-		log.Fatal("Error opening head ref of git repo: %v", err)
+		return "", fmt.Errorf("error opening head ref of git repo: %w", err)
 	}
 	if gitRef.Name().IsBranch() {
-		return gitRef.Name().String()
+		return gitRef.Name().String(), nil
 	}
 
-	return ""
+	return "", nil
 }
 
-func (r *repo) GetTagRef() string {
+func (r *repo) GetTagRef() (string, error) {
 	gitRef, err := r.gitRepo.Head()
 	if err != nil {
-		log.Fatal("Error opening head ref of git repo: %v", err)
+		return "", fmt.Errorf("error opening head ref of git repo: %w", err)
 	}
 	// find tag
 	var refName string
 	tags, err := r.gitRepo.Tags()
 	if err != nil {
-		return ""
+		return "", nil
 	}
 	tags.ForEach(func(tag *plumbing.Reference) error {
 		if gitRef.Hash() == tag.Hash() {
@@ -196,55 +241,28 @@ func (r *repo) GetTagRef() string {
 		return nil
 	})
 
-	return refName
+	return refName, nil
 }
 
-func (r *repo) GetCompHash(compDirName string) string {
-	h := md5.New()
-
-	err := filepath.Walk(r.ComponentDir(compDirName),
-		func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-
-			if !info.IsDir() {
-				// compPath := foxutils.Subpath(path, r.cfg.RepoPath)
-				f, err := os.Open(path)
-				if err != nil {
-					return err
-				}
-				defer f.Close()
-
-				if _, err := io.Copy(h, f); err != nil {
-					return err
-				}
-			}
-
-			return nil
-		})
-	if err != nil {
-		log.Fatal("Error generating Component hash: %v", err)
-	}
-
-	return hex.EncodeToString(h.Sum(nil))
-}
-
-func (r *repo) GetCommit() *object.Commit {
-	if !r.IsClean() {
-		log.Fatal("Error finding commit hash: uncommitted changes present")
+func (r *repo) GetCommit() (*object.Commit, error) {
+	if !r.cfg.Flags.Dirty {
+		if clean, err := r.IsClean(); err != nil {
+			return nil, err
+		} else if !clean {
+			return nil, fmt.Errorf("error finding commit hash: %w", ErrUncommittedChanges)
+		}
 	}
 	head, err := r.gitRepo.Head()
 	if err != nil {
-		log.Fatal("Error opening head ref of git repo: %v", err)
+		return nil, fmt.Errorf("error opening head ref of git repo: %w", err)
 	}
 
 	c, err := r.gitRepo.CommitObject(head.Hash())
 	if err != nil {
-		log.Fatal("Error getting commit '%s' for head ref of git repo: %v", head.Hash().String(), err)
+		return nil, fmt.Errorf("error getting commit '%s' for head ref of git repo: %w", head.Hash().String(), err)
 	}
 
-	return c
+	return c, nil
 }
 
 func (r *repo) AppYAMLBuildSubpath() string {
@@ -267,19 +285,19 @@ func (r *repo) ComponentRepoSubpath(comp string) string {
 	return foxutils.Subpath(r.ComponentDir(comp), r.cfg.RepoPath)
 }
 
-func (r *repo) IsClean() bool {
-	if os.Getenv("FOX_DRAGON_IGNORE_UNCOMMITTED") == "true" {
-		return true
+func (r *repo) IsClean() (bool, error) {
+	if r.ignoreUncommitted {
+		return true, nil
 	}
 
 	w, err := r.gitRepo.Worktree()
 	if err != nil {
-		log.Fatal("Error accessing git worktree: %v", err)
+		return false, fmt.Errorf("error accessing git worktree: %w", err)
 	}
 	s, err := w.Status()
 	if err != nil {
-		log.Fatal("Error getting git worktree status: %v", err)
+		return false, fmt.Errorf("error getting git worktree status: %w", err)
 	}
 
-	return s.IsClean()
+	return s.IsClean(), nil
 }
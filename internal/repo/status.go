@@ -0,0 +1,171 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package repo
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/xigxog/fox/internal/kubernetes"
+	"github.com/xigxog/kubefox/api"
+	"github.com/xigxog/kubefox/api/kubernetes/v1alpha1"
+	"github.com/xigxog/kubefox/k8s"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// AppStatus summarizes the state of the app on the cluster.
+type AppStatus struct {
+	App            string                `json:"app"`
+	Platform       PlatformStatus        `json:"platform"`
+	AppDeployments []AppDeploymentStatus `json:"appDeployments"`
+}
+
+type PlatformStatus struct {
+	Name       string            `json:"name"`
+	Namespace  string            `json:"namespace"`
+	Available  bool              `json:"available"`
+	Components []ComponentStatus `json:"components"`
+}
+
+type AppDeploymentStatus struct {
+	Name       string            `json:"name"`
+	Version    string            `json:"version,omitempty"`
+	Branch     string            `json:"branch,omitempty"`
+	Tag        string            `json:"tag,omitempty"`
+	Commit     string            `json:"commit"`
+	Dirty      bool              `json:"dirty,omitempty"`
+	Available  bool              `json:"available"`
+	Releases   []ReleaseRef      `json:"releases,omitempty"`
+	Components []ComponentStatus `json:"components"`
+}
+
+// ReleaseRef is a VirtualEnvironment releasing an AppDeployment.
+type ReleaseRef struct {
+	VirtualEnv string `json:"virtualEnvironment"`
+	Pending    bool   `json:"pending,omitempty"`
+}
+
+// ComponentStatus is the readiness of the pods of a component with a specific
+// hash.
+type ComponentStatus struct {
+	Name  string `json:"name"`
+	Hash  string `json:"hash,omitempty"`
+	Ready int    `json:"ready"`
+	Pods  int    `json:"pods"`
+}
+
+func (r *repo) Status() (*AppStatus, error) {
+	p, err := r.k8s.GetPlatform(r.ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pods, err := r.k8s.ListPlatformPods(r.ctx, p)
+	if err != nil {
+		return nil, fmt.Errorf("error getting component pods: %w", err)
+	}
+	platformComps := []string{api.PlatformComponentNATS, api.PlatformComponentBroker, api.PlatformComponentHTTPSrv}
+
+	type podKey struct{ name, hash string }
+	compPods := map[podKey]*ComponentStatus{}
+	for i, pod := range pods {
+		k := podKey{name: pod.Labels[api.LabelK8sComponent], hash: pod.Labels[api.LabelK8sComponentHash]}
+		// The hash of Platform components is derived from the Platform's
+		// commits, their pods are grouped by name only.
+		if pod.Labels[api.LabelK8sAppName] != r.app.Name && slices.Contains(platformComps, k.name) {
+			k.hash = ""
+		}
+		c, found := compPods[k]
+		if !found {
+			c = &ComponentStatus{Name: k.name, Hash: k.hash}
+			compPods[k] = c
+		}
+		c.Pods++
+		if kubernetes.IsPodReady(&pods[i]) {
+			c.Ready++
+		}
+	}
+	compStatus := func(name, hash string) ComponentStatus {
+		if c, found := compPods[podKey{name: name, hash: hash}]; found {
+			return *c
+		}
+		return ComponentStatus{Name: name, Hash: hash}
+	}
+
+	status := &AppStatus{
+		App: r.app.Name,
+		Platform: PlatformStatus{
+			Name:      p.Name,
+			Namespace: p.Namespace,
+			Available: k8s.IsAvailable(p.Status.Conditions),
+		},
+		AppDeployments: []AppDeploymentStatus{},
+	}
+	for _, n := range platformComps {
+		status.Platform.Components = append(status.Platform.Components, compStatus(n, ""))
+	}
+
+	appDepList := &v1alpha1.AppDeploymentList{}
+	if err := r.k8s.List(r.ctx, appDepList, client.InNamespace(p.Namespace)); err != nil {
+		return nil, fmt.Errorf("error listing AppDeployments: %w", err)
+	}
+	veList := &v1alpha1.VirtualEnvironmentList{}
+	if err := r.k8s.List(r.ctx, veList, client.InNamespace(p.Namespace)); err != nil {
+		return nil, fmt.Errorf("error listing VirtualEnvironments: %w", err)
+	}
+
+	for _, appDep := range appDepList.Items {
+		if appDep.Spec.AppName != r.app.Name {
+			continue
+		}
+
+		s := AppDeploymentStatus{
+			Name:       appDep.Name,
+			Version:    appDep.Spec.Version,
+			Branch:     appDep.Spec.Branch,
+			Tag:        appDep.Spec.Tag,
+			Commit:     appDep.Spec.Commit,
+			Dirty:      appDep.Labels[LabelDirty] == "true",
+			Available:  k8s.IsAvailable(appDep.Status.Conditions),
+			Components: []ComponentStatus{},
+		}
+		for _, ve := range veList.Items {
+			if releases(ve.Status.ActiveRelease, r.app.Name, appDep.Name) {
+				s.Releases = append(s.Releases, ReleaseRef{VirtualEnv: ve.Name})
+			}
+			if releases(ve.Status.PendingRelease, r.app.Name, appDep.Name) {
+				s.Releases = append(s.Releases, ReleaseRef{VirtualEnv: ve.Name, Pending: true})
+			}
+		}
+		for name, comp := range appDep.Spec.Components {
+			s.Components = append(s.Components, compStatus(name, comp.Hash))
+		}
+		sort.Slice(s.Components, func(i, j int) bool {
+			return s.Components[i].Name < s.Components[j].Name
+		})
+
+		status.AppDeployments = append(status.AppDeployments, s)
+	}
+	sort.Slice(status.AppDeployments, func(i, j int) bool {
+		return status.AppDeployments[i].Name < status.AppDeployments[j].Name
+	})
+
+	return status, nil
+}
+
+// releases returns true if the Release includes the AppDeployment of the app.
+func releases(rel *v1alpha1.ReleaseStatus, app, appDep string) bool {
+	if rel == nil {
+		return false
+	}
+	a, found := rel.Apps[app]
+
+	return found && a.AppDeployment == appDep
+}
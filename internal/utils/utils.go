@@ -17,17 +17,19 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/xigxog/fox/internal/errs"
 	"github.com/xigxog/fox/internal/log"
 	"github.com/xigxog/kubefox/utils"
+	"golang.org/x/term"
 )
 
-func Wd() string {
+func Wd() (string, error) {
 	wd, err := os.Getwd()
 	if err != nil {
-		log.Fatal("Error getting working dir: %v", err)
+		return "", fmt.Errorf("error getting working dir: %w", err)
 	}
 
-	return filepath.Clean(wd)
+	return filepath.Clean(wd), nil
 }
 
 func Find(file, path, stop string) string {
@@ -62,29 +64,58 @@ func FileExists(path string) bool {
 	return !info.IsDir()
 }
 
-func EnsureDirForFile(path string) {
-	EnsureDir(filepath.Dir(path))
+func EnsureDirForFile(path string) error {
+	return EnsureDir(filepath.Dir(path))
 }
 
-func EnsureDir(path string) {
+func EnsureDir(path string) error {
 	if err := os.MkdirAll(path, os.ModePerm); err != nil {
-		log.Fatal("Error creating directory: %s", err)
+		return fmt.Errorf("error creating directory: %w", err)
 	}
+
+	return nil
 }
 
-func IsDirEmpty(name string) bool {
+func IsDirEmpty(name string) (bool, error) {
 	f, err := os.Open(name)
 	if err != nil {
-		log.Fatal("%v", err)
+		return false, err
 	}
 	defer f.Close()
 
 	_, err = f.Readdirnames(1)
 
-	return err == io.EOF
+	return err == io.EOF, nil
+}
+
+// IsTerminal returns true if stdin is a terminal that can be prompted.
+func IsTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// Prompter prompts the user for input.
+type Prompter struct {
+	// NoInput disables prompts. Yes/no prompts are answered with their default
+	// and input prompts use their default value, returning an error if input
+	// is required and there is no default.
+	NoInput bool
+	// AssumeYes answers yes to all yes/no prompts.
+	AssumeYes bool
 }
 
-func YesNoPrompt(prompt string, def bool) bool {
+func (p Prompter) YesNo(prompt string, def bool) bool {
+	switch {
+	case p.AssumeYes:
+		log.Verbose("%s [yes, 'yes' flag set]", prompt)
+		return true
+	case p.NoInput && def:
+		log.Verbose("%s [yes, prompts disabled]", prompt)
+		return def
+	case p.NoInput:
+		log.Warn("Prompts are disabled, answering no to '%s', set the 'yes' flag to answer yes.", prompt)
+		return def
+	}
+
 	if def {
 		log.Printf(prompt + " [Y/n] ")
 	} else {
@@ -103,11 +134,22 @@ func YesNoPrompt(prompt string, def bool) bool {
 	case "n":
 		return false
 	default:
-		return YesNoPrompt(prompt, def)
+		return p.YesNo(prompt, def)
 	}
 }
 
-func InputPrompt(prompt, def string, required bool) string {
+// Input prompts for input. If prompts are disabled the default is used. If the
+// input is required and there is no default an error naming flag is returned,
+// flag should be the flag that provides the input.
+func (p Prompter) Input(prompt, def string, required bool, flag string) (string, error) {
+	if p.NoInput {
+		if required && def == "" {
+			return "", errs.New(errs.TypeUsage, "prompts are disabled and '%s' is required, set the '%s' flag", prompt, flag)
+		}
+		log.Verbose("%s [%s, prompts disabled]", prompt, def)
+		return def, nil
+	}
+
 	log.Printf(prompt)
 	if def != "" {
 		log.Printf(" (default '%s')", def)
@@ -124,20 +166,23 @@ func InputPrompt(prompt, def string, required bool) string {
 		input = def
 	}
 	if required && input == "" {
-		return InputPrompt(prompt, def, required)
+		return p.Input(prompt, def, required, flag)
 	}
-	return input
+	return input, nil
 }
 
-func NamePrompt(what, def string, required bool) string {
-	name := InputPrompt(fmt.Sprintf("Enter the %s's name", what), def, required)
+func (p Prompter) Name(what, def string, required bool, flag string) (string, error) {
+	name, err := p.Input(fmt.Sprintf("Enter the %s's name", what), def, required, flag)
+	if err != nil {
+		return "", err
+	}
 	if !utils.IsValidName(name) {
 		log.Error("The %s's name is invalid.", what)
-		if YesNoPrompt(fmt.Sprintf("Would you like to use '%s' instead", utils.CleanName(name)), true) {
-			return utils.CleanName(name)
+		if p.YesNo(fmt.Sprintf("Would you like to use '%s' instead", utils.CleanName(name)), true) {
+			return utils.CleanName(name), nil
 		} else {
-			return NamePrompt(what, def, required)
+			return p.Name(what, def, required, flag)
 		}
 	}
-	return name
+	return name, nil
 }
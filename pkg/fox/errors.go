@@ -0,0 +1,50 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+package fox
+
+import (
+	"github.com/xigxog/fox/internal/errs"
+	"github.com/xigxog/fox/internal/repo"
+)
+
+// Error is an error of a specific ErrorType. Use errors.As to access it or
+// ErrorTypeOf to get the ErrorType of any error returned by a Client.
+type Error = errs.Error
+
+type ErrorType = errs.Type
+
+const (
+	ErrorTypeGeneral      = errs.TypeGeneral
+	ErrorTypeUsage        = errs.TypeUsage
+	ErrorTypeNotFound     = errs.TypeNotFound
+	ErrorTypeMissingImage = errs.TypeMissingImage
+	ErrorTypeValidation   = errs.TypeValidation
+	ErrorTypeUnauthorized = errs.TypeUnauthorized
+	ErrorTypeDirty        = errs.TypeDirty
+	ErrorTypeTimeout      = errs.TypeTimeout
+	ErrorTypeUnavailable  = errs.TypeUnavailable
+	ErrorTypeBuildFailed  = errs.TypeBuildFailed
+	ErrorTypeAborted      = errs.TypeAborted
+	ErrorTypeNotReady     = errs.TypeNotReady
+	ErrorTypeMismatch     = errs.TypeMismatch
+)
+
+var (
+	ErrUncommittedChanges = repo.ErrUncommittedChanges
+	ErrMissingImages      = repo.ErrMissingImages
+	ErrBuildFailed        = repo.ErrBuildFailed
+	ErrDirtyRelease       = repo.ErrDirtyRelease
+	ErrReleaseProblems    = repo.ErrReleaseProblems
+)
+
+// ErrorTypeOf returns the ErrorType of err, ErrorTypeGeneral is returned if it
+// cannot be determined.
+func ErrorTypeOf(err error) ErrorType {
+	return errs.TypeOf(err)
+}
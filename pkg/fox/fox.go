@@ -0,0 +1,352 @@
+// Copyright 2023 XigXog
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+//
+// SPDX-License-Identifier: MPL-2.0
+
+// Package fox provides the operations of the 🦊 Fox CLI as a library. Unlike
+// the CLI it never exits the process, problems are returned as errors instead,
+// and prompts are only shown if Options.Prompt is set.
+package fox
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/xigxog/fox/internal/config"
+	"github.com/xigxog/fox/internal/repo"
+	"github.com/xigxog/kubefox/api/kubernetes/v1alpha1"
+)
+
+const (
+	BuilderDocker  = repo.BuilderDocker
+	BuilderBuildah = repo.BuilderBuildah
+	BuilderNative  = repo.BuilderNative
+)
+
+// DefaultHistoryLimit is the number of release history records of each app
+// kept if ReleaseOptions.HistoryLimit is zero.
+const DefaultHistoryLimit = repo.DefaultHistoryLimit
+
+// AppDeploymentDiff contains the changes between the AppDeployment on the
+// cluster and the one generated from the repo.
+type AppDeploymentDiff = repo.AppDeploymentDiff
+
+type DiffChange = repo.DiffChange
+
+type DiffOp = repo.DiffOp
+
+const (
+	DiffOpAdd    = repo.DiffOpAdd
+	DiffOpRemove = repo.DiffOpRemove
+	DiffOpChange = repo.DiffOpChange
+)
+
+// ReleasePlan describes the changes releasing an AppDeployment to a
+// VirtualEnvironment would make.
+type ReleasePlan = repo.ReleasePlan
+
+// Options configure a Client.
+type Options struct {
+	// AppPath is the path of the KubeFox App, defaults to the working
+	// directory.
+	AppPath string
+
+	// Platform and Namespace identify the KubeFox Platform to utilize. If not
+	// set the only Platform in the cluster is used.
+	Platform  string
+	Namespace string
+
+	// Registry is the container registry component images are pushed to.
+	Registry Registry
+
+	// Builder is the backend used to build and inspect images, defaults to
+	// BuilderDocker.
+	Builder string
+	// OCILayout is the directory used to store images built by the native
+	// builder.
+	OCILayout string
+	// KindCluster is the name of the kind cluster built images are loaded
+	// into, if any.
+	KindCluster string
+
+	// ConfigFile is the path of a 🦊 Fox config file, such as the one created
+	// by 'fox config setup'. It provides defaults for the container registry,
+	// Platform, and kind cluster, and Platforms selected at a prompt are
+	// remembered in it. No file is read if empty.
+	ConfigFile string
+	// Prompt shows interactive prompts on the terminal, for example to select
+	// a Platform or confirm changes.
+	Prompt bool
+	// AssumeYes answers yes to all confirmations without prompting.
+	AssumeYes bool
+}
+
+// Registry is a container registry.
+type Registry struct {
+	Address  string
+	Token    string
+	Username string
+}
+
+// BuildOptions configure Client.Build.
+type BuildOptions struct {
+	// Component is the name of the component directory to build.
+	Component string
+	// Push pushes the image to the container registry after it is built.
+	Push    bool
+	NoCache bool
+	// Force builds the image even if it already exists.
+	Force bool
+}
+
+// DeployOptions configure Client.Deploy.
+type DeployOptions struct {
+	// Name of the AppDeployment, defaults to
+	// <APP NAME>-<VERSION | GIT REF | GIT COMMIT>.
+	Name string
+	// Version makes the AppDeployment immutable.
+	Version string
+	// CreateTag creates a Git tag using Version.
+	CreateTag bool
+	// Dirty allows uncommitted changes to be deployed.
+	Dirty bool
+	// Wait is the maximum time to wait for components to be ready.
+	Wait   time.Duration
+	DryRun bool
+	// Diff shows the changes to the AppDeployment on the cluster and asks for
+	// confirmation before applying them. Changes are not applied unless
+	// Options.Prompt or Options.AssumeYes is set.
+	Diff bool
+	// Generate returns the AppDeployment without applying it.
+	Generate bool
+}
+
+// PublishOptions configure Client.Publish.
+type PublishOptions struct {
+	DeployOptions
+
+	// SkipPush does not push images after they are built.
+	SkipPush bool
+	// SkipDeploy does not deploy the app after its images are built.
+	SkipDeploy bool
+	// Parallel is the number of components built concurrently, defaults to 1.
+	Parallel int
+	NoCache  bool
+	// Force builds images even if they already exist.
+	Force bool
+}
+
+// ReleaseOptions configure Client.Release.
+type ReleaseOptions struct {
+	// AppDeployment is the name, version, tag, branch, or commit of the
+	// AppDeployment to release.
+	AppDeployment string
+	// VirtualEnv is the name of the VirtualEnvironment to release to.
+	VirtualEnv string
+	// Wait is the maximum time to wait for the Release to be available.
+	Wait   time.Duration
+	DryRun bool
+	// HistoryLimit is the maximum number of release history records kept for
+	// the app, older records are dropped. Zero uses DefaultHistoryLimit.
+	HistoryLimit int
+}
+
+// Client builds, deploys, publishes, and releases a KubeFox App.
+type Client struct {
+	opts Options
+}
+
+func New(opts Options) (*Client, error) {
+	if opts.Platform != "" && opts.Namespace == "" {
+		return nil, errors.New("namespace is required if platform is provided")
+	}
+	if opts.Builder == "" {
+		opts.Builder = BuilderDocker
+	}
+
+	return &Client{opts: opts}, nil
+}
+
+// Build builds the component's image and returns its name.
+func (c *Client) Build(ctx context.Context, opts BuildOptions) (string, error) {
+	cfg, err := c.config()
+	if err != nil {
+		return "", err
+	}
+	cfg.Flags.PushImage = opts.Push
+	cfg.Flags.NoCache = opts.NoCache
+	cfg.Flags.ForceBuild = opts.Force
+
+	r, err := repo.Open(ctx, cfg)
+	if err != nil {
+		return "", err
+	}
+
+	return r.Build(opts.Component)
+}
+
+// Deploy deploys the app using the component code from the currently checked
+// out Git commit. Missing component images are built first.
+func (c *Client) Deploy(ctx context.Context, opts DeployOptions) (*v1alpha1.AppDeployment, error) {
+	cfg, err := c.config()
+	if err != nil {
+		return nil, err
+	}
+	if err := setDeployFlags(cfg, &opts); err != nil {
+		return nil, err
+	}
+
+	r, err := repo.Open(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Deploy(false)
+}
+
+// Diff compares the AppDeployment that Deploy would apply with the one on the
+// cluster. Wait, DryRun, Diff, and Generate are ignored and nothing is built or
+// applied.
+func (c *Client) Diff(ctx context.Context, opts DeployOptions) (*AppDeploymentDiff, error) {
+	cfg, err := c.config()
+	if err != nil {
+		return nil, err
+	}
+	if err := setDeployFlags(cfg, &opts); err != nil {
+		return nil, err
+	}
+
+	r, err := repo.Open(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Diff()
+}
+
+// Publish builds and pushes all component images and deploys the app. The
+// returned AppDeployment is nil if SkipDeploy is set.
+func (c *Client) Publish(ctx context.Context, opts PublishOptions) (*v1alpha1.AppDeployment, error) {
+	cfg, err := c.config()
+	if err != nil {
+		return nil, err
+	}
+	if err := setDeployFlags(cfg, &opts.DeployOptions); err != nil {
+		return nil, err
+	}
+	cfg.Flags.PushImage = !opts.SkipPush
+	cfg.Flags.SkipDeploy = opts.SkipDeploy
+	if opts.SkipPush && cfg.Flags.Kind == "" && !cfg.Kind.AlwaysLoad {
+		cfg.Flags.SkipDeploy = true
+	}
+	cfg.Flags.Parallel = max(opts.Parallel, 1)
+	cfg.Flags.NoCache = opts.NoCache
+	cfg.Flags.ForceBuild = opts.Force
+
+	r, err := repo.Open(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Publish()
+}
+
+// Release releases the AppDeployment to the VirtualEnvironment.
+func (c *Client) Release(ctx context.Context, opts ReleaseOptions) (*v1alpha1.VirtualEnvironment, error) {
+	if opts.AppDeployment == "" {
+		return nil, errors.New("AppDeployment is required")
+	}
+	if opts.VirtualEnv == "" {
+		return nil, errors.New("VirtualEnv is required")
+	}
+
+	cfg, err := c.config()
+	if err != nil {
+		return nil, err
+	}
+	cfg.Flags.VirtEnv = opts.VirtualEnv
+	cfg.Flags.WaitTime = opts.Wait
+	cfg.Flags.DryRun = opts.DryRun
+	cfg.Flags.HistoryLimit = opts.HistoryLimit
+
+	r, err := repo.Open(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Release(opts.AppDeployment)
+}
+
+// PlanRelease returns the changes Release would make without releasing. Wait
+// and DryRun are ignored.
+func (c *Client) PlanRelease(ctx context.Context, opts ReleaseOptions) (*ReleasePlan, error) {
+	if opts.AppDeployment == "" {
+		return nil, errors.New("AppDeployment is required")
+	}
+	if opts.VirtualEnv == "" {
+		return nil, errors.New("VirtualEnv is required")
+	}
+
+	cfg, err := c.config()
+	if err != nil {
+		return nil, err
+	}
+	cfg.Flags.VirtEnv = opts.VirtualEnv
+
+	r, err := repo.Open(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.PlanRelease(opts.AppDeployment)
+}
+
+// config returns a new Config using the Client's Options.
+func (c *Client) config() (*config.Config, error) {
+	cfg := &config.Config{}
+	if c.opts.ConfigFile != "" {
+		var err error
+		if cfg, err = config.Read(c.opts.ConfigFile); err != nil {
+			return nil, err
+		}
+	}
+	cfg.Flags = config.Flags{
+		AppPath:          c.opts.AppPath,
+		Platform:         c.opts.Platform,
+		Namespace:        c.opts.Namespace,
+		RegistryAddress:  c.opts.Registry.Address,
+		RegistryToken:    c.opts.Registry.Token,
+		RegistryUsername: c.opts.Registry.Username,
+		Builder:          c.opts.Builder,
+		OCILayout:        c.opts.OCILayout,
+		Kind:             c.opts.KindCluster,
+		NoInput:          !c.opts.Prompt,
+		Yes:              c.opts.AssumeYes,
+	}
+
+	return cfg, nil
+}
+
+func setDeployFlags(cfg *config.Config, opts *DeployOptions) error {
+	if opts.CreateTag && opts.Version == "" {
+		return errors.New("version is required if CreateTag is set")
+	}
+	if opts.Dirty && opts.Version != "" {
+		return errors.New("version cannot be used with Dirty, only committed changes can be versioned")
+	}
+
+	cfg.Flags.AppDeployment = opts.Name
+	cfg.Flags.Version = opts.Version
+	cfg.Flags.CreateTag = opts.CreateTag
+	cfg.Flags.Dirty = opts.Dirty
+	cfg.Flags.WaitTime = opts.Wait
+	cfg.Flags.DryRun = opts.DryRun
+	cfg.Flags.Diff = opts.Diff
+	cfg.Flags.Generate = opts.Generate
+
+	return nil
+}